@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The types of the audit events
+const (
+	EventSigninSuccess  = "signin.success"
+	EventSigninFailure  = "signin.failure"
+	EventMFARequired    = "signin.mfa_required"
+	EventRefresh        = "token.refresh"
+	EventRefreshReuse   = "token.reuse"
+	EventLogout         = "logout"
+	EventLockout        = "lockout"
+	EventUnlock         = "unlock"
+	EventPasswordChange = "password.change"
+	EventPasswordFailed = "password.change_failure"
+)
+
+// AuditEvent is one line of the audit log.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Subject   string    `json:"subject,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// AuditSink receives the audit events. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	Write(event AuditEvent) error
+}
+
+// WriterSink writes the events as JSON lines to an io.Writer.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// FileSink appends the events as JSON lines to a file. When the file would
+// grow past maxSize bytes, it is renamed to path.1, path.1 to path.2 and so
+// on, keeping at most maxBackups old files.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	// openFile is os.OpenFile, replaced by the tests
+	openFile func(name string, flag int, perm os.FileMode) (*os.File, error)
+
+	mu sync.Mutex
+	// file is nil after a rotation until the next file is opened
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending, creating it if needed. At least one
+// old file must be kept, since the log is append-only and a rotation never
+// deletes the current file.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxBackups < 1 {
+		return nil, errors.New("the audit log must keep at least one rotated file")
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups, openFile: os.OpenFile}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := s.openFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// rotate closes the current file and moves it out of the way. Write then
+// opens a new one, or the current file again if it could not be moved.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	return errors.Join(err, s.shift())
+}
+
+// shift renames the backups and the closed current file
+func (s *FileSink) shift() error {
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+func (s *FileSink) Write(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rotateErr error
+	if s.file != nil && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		// after a failed rotation the current file is opened again, so the
+		// event is still written and the rotation is tried again next time
+		rotateErr = s.rotate()
+	}
+	if s.file == nil {
+		// the file is opened after a rotation, or again after failing to
+		if err := s.open(); err != nil {
+			return errors.Join(rotateErr, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+// Close closes the current file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// The audit events are written to an AuditSink, which is set up in main
+var auditSink AuditSink = NewWriterSink(io.Discard)
+
+// audit records an event about subject, made by the client of r
+func audit(r *http.Request, eventType, subject, detail string) {
+	err := auditSink.Write(AuditEvent{
+		Time:      clock().UTC(),
+		Type:      eventType,
+		Subject:   subject,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Println("Writing the audit log failed:", err)
+	}
+}
+
+// AuditFilter selects audit events. Empty fields match every event.
+type AuditFilter struct {
+	Subject string
+	Type    string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f AuditFilter) Match(e AuditEvent) bool {
+	return (f.Subject == "" || e.Subject == f.Subject) &&
+		(f.Type == "" || e.Type == f.Type) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// FilterAudit copies the JSON lines of r matching f to w.
+func FilterAudit(w io.Writer, r io.Reader, f AuditFilter) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if f.Match(e) {
+			if _, err := fmt.Fprintf(w, "%s\n", scanner.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// runAuditCommand is the "audit" subcommand, which prints the events of the
+// audit log and its rotated files, oldest first, matching the flags.
+func runAuditCommand(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("file", "audit.log", "audit log to read, along with its rotated files")
+	var f AuditFilter
+	fs.StringVar(&f.Subject, "user", "", "only the events about this user")
+	fs.StringVar(&f.Type, "type", "", "only the events of this type, such as signin.failure")
+	since := fs.String("since", "", "only the events at or after this RFC 3339 time")
+	until := fs.String("until", "", "only the events before this RFC 3339 time")
+	fs.Parse(args)
+
+	var err error
+	if *since != "" {
+		if f.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("-since: %w", err)
+		}
+	}
+	if *until != "" {
+		if f.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+			return fmt.Errorf("-until: %w", err)
+		}
+	}
+
+	// The rotated files have the highest numbers for the oldest events
+	files := []string{*path}
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%s.%d", *path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = FilterAudit(out, file, f)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
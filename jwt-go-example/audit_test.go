@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+func TestAuditSignin(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	var buf bytes.Buffer
+	oldSink := auditSink
+	auditSink = NewWriterSink(&buf)
+	t.Cleanup(func() { auditSink = oldSink })
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+	if rec := call(t, Signin, Credentials{Username: "alice", Password: "wrong"}, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d", rec.Code)
+	}
+	now = now.Add(userThrottle.cfg.BaseDelay)
+	if rec := call(t, Signin, creds, ""); rec.Code != http.StatusOK {
+		t.Fatalf("signin: got %d", rec.Code)
+	}
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e AuditEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		if e.Subject != "alice" || e.IP == "" || e.Time.IsZero() {
+			t.Errorf("incomplete event %+v", e)
+		}
+		types = append(types, e.Type)
+	}
+	if got := strings.Join(types, ","); got != EventSigninFailure+","+EventSigninSuccess {
+		t.Errorf("got events %s", got)
+	}
+}
+
+func TestSignupProblems(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	call(t, Signup, creds, "")
+	tests := []struct {
+		creds  Credentials
+		status int
+		fields int
+	}{
+		{creds, http.StatusConflict, 0},
+		{Credentials{Username: "a", Password: "weak"}, http.StatusUnprocessableEntity, 2},
+	}
+	for _, tt := range tests {
+		rec := call(t, Signup, tt.creds, "")
+		p := decode[problem.Problem](t, rec)
+		if rec.Code != tt.status || p.Status != tt.status || len(p.Errors) != tt.fields ||
+			rec.Header().Get("Content-Type") != problem.ContentType {
+			t.Errorf("signup %s: got %d %s", tt.creds.Username, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	start := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		e := AuditEvent{Time: start.Add(time.Duration(i) * time.Hour), Type: EventSigninSuccess, Subject: "alice"}
+		if i%2 == 1 {
+			e.Subject = "bob"
+		}
+		if err := sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("%s has %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more backups than allowed")
+	}
+
+	// the current file holds the latest events, filtered by user and time
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	f := AuditFilter{Subject: "bob", Since: start.Add(9 * time.Hour)}
+	if err := FilterAudit(&out, bytes.NewReader(data), f); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 1 || !strings.Contains(out.String(), `"bob"`) {
+		t.Errorf("filtered %q", out.String())
+	}
+}
+
+func TestFileSinkRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileSink(path, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	// a directory in the way of the backup makes the rename fail
+	if err := os.Mkdir(path+".1", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	e := AuditEvent{Time: time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC), Type: EventSigninSuccess, Subject: "alice"}
+	if err := sink.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(e); err == nil {
+		t.Fatal("rotation into a directory succeeded")
+	}
+
+	// the sink is still open on the original file and rotates once it can
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	e.Subject = "bob"
+	if err := sink.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(backup), `"alice"`); n != 2 {
+		t.Errorf("backup has %d events of alice: %s", n, backup)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(current), `"bob"`) || strings.Contains(string(current), `"alice"`) {
+		t.Errorf("current file: %s", current)
+	}
+}
+
+func TestFileSinkReopenFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if _, err := NewFileSink(path, 100, 0); err == nil {
+		t.Fatal("a sink deleting the log on rotation was created")
+	}
+	sink, err := NewFileSink(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	e := AuditEvent{Time: time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC), Type: EventSigninSuccess, Subject: "alice"}
+	if err := sink.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	// the file cannot be opened once, as when running out of descriptors
+	failed := false
+	sink.openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		if !failed {
+			failed = true
+			return nil, syscall.EMFILE
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+	if err := sink.Write(e); !errors.Is(err, syscall.EMFILE) {
+		t.Fatalf("write while the file cannot be opened: got %v", err)
+	}
+
+	// the next write opens the file again
+	for _, subject := range []string{"bob", "carol"} {
+		e.Subject = subject
+		if err := sink.Write(e); err != nil {
+			t.Fatalf("write of %s after the failure: %v", subject, err)
+		}
+	}
+	var all []byte
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, data...)
+	}
+	if n := strings.Count(string(all), "\n"); n != 3 || !strings.HasSuffix(string(all), `"carol"}`+"\n") {
+		t.Errorf("the log holds %d events: %s", n, all)
+	}
+}
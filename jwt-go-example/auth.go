@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthConfig holds the values checked on every access token.
+type AuthConfig struct {
+	// Issuer is set as the "iss" claim and must match on verification
+	Issuer string
+	// Audience is set as the "aud" claim and must be present on verification
+	Audience string
+	// ClockSkew is the leeway allowed when checking "exp", "nbf" and "iat"
+	ClockSkew time.Duration
+}
+
+// The settings used to issue and verify the access tokens, which can be changed in main
+var authConfig = AuthConfig{
+	Issuer:    "jwt-go-example",
+	Audience:  "jwt-go-example",
+	ClockSkew: 30 * time.Second,
+}
+
+// clock returns the current time. Tests replace it to run against a fixed clock
+var clock = time.Now
+
+// mfaTokenTTL is the lifetime of the tokens exchanged at /signin/mfa
+const mfaTokenTTL = 5 * time.Minute
+
+var (
+	errMissingToken = errors.New("missing access token")
+	errBadAuthz     = errors.New("authorization header must use the Bearer scheme")
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+// ContextWithClaims returns a copy of ctx carrying claims.
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by RequireAuth.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// tokenFromRequest reads the access token from the "Authorization: Bearer"
+// header, or else from the "token" cookie
+func tokenFromRequest(r *http.Request) (string, error) {
+	if authz := r.Header.Get("Authorization"); authz != "" {
+		scheme, token, ok := strings.Cut(authz, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", errBadAuthz
+		}
+		return strings.TrimSpace(token), nil
+	}
+	c, err := r.Cookie(tokenCookie)
+	if err != nil || c.Value == "" {
+		return "", errMissingToken
+	}
+	return c.Value, nil
+}
+
+// The IDs of the access tokens revoked before they expire are stored in a RevocationStore, which is set up in main
+var revocations RevocationStore
+
+// errRevocationCheck is returned when the RevocationStore cannot be queried
+var errRevocationCheck = errors.New("cannot check token revocation")
+
+// ParseAccessToken verifies the signature, algorithm, issuer, audience and
+// expiry of an access token, checks that neither it nor its session has been
+// revoked, and returns its claims.
+func ParseAccessToken(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString, authConfig.Audience)
+	if err != nil {
+		return nil, err
+	}
+	// The token is denied if either itself or its session was revoked
+	for _, id := range []string{claims.ID, claims.SessionID} {
+		if id == "" {
+			continue
+		}
+		revoked, err := revocations.IsRevoked(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errRevocationCheck, err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// mfaAudience is the audience of the tokens returned by Signin to users with
+// two-factor authentication. RequireAuth does not accept these tokens.
+func mfaAudience() string {
+	return authConfig.Audience + "/mfa"
+}
+
+// IssueMFAToken returns a short-lived token proving that username gave the
+// right password, to be exchanged at /signin/mfa along with a valid code.
+func IssueMFAToken(username string) (string, error) {
+	now := clock()
+	return keys.Sign(&Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    authConfig.Issuer,
+			Subject:   username,
+			Audience:  jwt.ClaimStrings{mfaAudience()},
+		},
+	})
+}
+
+// ParseMFAToken verifies a token returned by IssueMFAToken.
+func ParseMFAToken(tokenString string) (*Claims, error) {
+	return parseToken(tokenString, mfaAudience())
+}
+
+func parseToken(tokenString, audience string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc,
+		jwt.WithValidMethods(keys.Algs()),
+		jwt.WithIssuer(authConfig.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(authConfig.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(clock),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RequireAuth only calls next for requests carrying a valid access token
+// of a user, and answers 403 to the tokens of OAuth clients. The token's
+// claims are available to next through ClaimsFromContext.
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := tokenFromRequest(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jwt-go-example"`)
+			writeError(w, r, http.StatusUnauthorized, "invalid_request", err.Error())
+			return
+		}
+		claims, err := ParseAccessToken(r.Context(), tokenString)
+		if errors.Is(err, errRevocationCheck) {
+			problem.Write(w, r, err)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jwt-go-example", error="invalid_token"`)
+			description := "the access token is invalid"
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				description = "the access token has expired"
+			case errors.Is(err, ErrTokenRevoked):
+				description = "the access token has been revoked"
+			}
+			writeError(w, r, http.StatusUnauthorized, "invalid_token", description)
+			return
+		}
+		if claims.ClientID != "" {
+			// the tokens of OAuth clients are for the other services, and
+			// there is no user for them to act as here
+			writeError(w, r, http.StatusForbidden, "forbidden", "the tokens of OAuth clients cannot be used on user endpoints")
+			return
+		}
+		if claims.SessionID != "" {
+			if err := sessions.Touch(r.Context(), claims.SessionID, clientIP(r)); err != nil {
+				log.Println("Updating the last seen time of a session failed:", err)
+			}
+		}
+		next(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	}
+}
+
+// ErrorResponse is the JSON body of the errors of the OAuth endpoints, whose
+// format is set by RFC 6749. The other endpoints answer problem details.
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// writeError answers a problem with the given error code, such as
+// "invalid_token", in its "code" member
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	problem.Error(w, r, status, code, description)
+}
+
+// writeDecodeError answers a request whose body is not the expected JSON
+func writeDecodeError(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, "invalid_request", "the request body is not valid JSON")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessClaims returns the claims of an access token for alice issued at now
+func accessClaims(now time.Time) *Claims {
+	return &Claims{
+		Username: "alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    authConfig.Issuer,
+			Subject:   "alice",
+			Audience:  jwt.ClaimStrings{authConfig.Audience},
+		},
+	}
+}
+
+func sign(t *testing.T, claims *Claims) string {
+	t.Helper()
+	token, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireAuthTokenSources(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	valid := sign(t, accessClaims(now))
+
+	serve := func(authorization, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/welcome", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: tokenCookie, Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		RequireAuth(Welcome)(rec, req)
+		return rec
+	}
+	for _, tt := range []struct {
+		name, authorization, cookie string
+		want                        int
+	}{
+		{"bearer", "Bearer " + valid, "", http.StatusOK},
+		{"lower case scheme", "bearer " + valid, "", http.StatusOK},
+		{"cookie", "", valid, http.StatusOK},
+		// the header wins over the cookie, even when it is wrong
+		{"header before cookie", "Bearer garbage", valid, http.StatusUnauthorized},
+		{"basic scheme", "Basic YWxpY2U6cGFzcw==", valid, http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", "", http.StatusUnauthorized},
+		{"nothing", "", "", http.StatusUnauthorized},
+	} {
+		rec := serve(tt.authorization, tt.cookie)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d %s", tt.name, rec.Code, rec.Body)
+		}
+		if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	ctx := context.Background()
+
+	with := func(change func(c *Claims)) string {
+		claims := accessClaims(now)
+		change(claims)
+		return sign(t, claims)
+	}
+	unsigned := func(method jwt.SigningMethod, key any) string {
+		token := jwt.NewWithClaims(method, accessClaims(now))
+		token.Header["kid"] = keys.keys[0].ID
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	skew := authConfig.ClockSkew
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", with(func(*Claims) {}), nil},
+		{"wrong issuer", with(func(c *Claims) { c.Issuer = "someone-else" }), jwt.ErrTokenInvalidIssuer},
+		{"wrong audience", with(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-api"} }), jwt.ErrTokenInvalidAudience},
+		{"mfa audience", with(func(c *Claims) { c.Audience = jwt.ClaimStrings{mfaAudience()} }), jwt.ErrTokenInvalidAudience},
+		{"no expiry", with(func(c *Claims) { c.ExpiresAt = nil }), jwt.ErrTokenRequiredClaimMissing},
+		{"expired within the skew", with(func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-skew + time.Second)) }), nil},
+		{"expired beyond the skew", with(func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-skew - time.Second)) }), jwt.ErrTokenExpired},
+		{"issued in the future within the skew", with(func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(skew - time.Second)) }), nil},
+		{"issued in the future beyond the skew", with(func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(skew + time.Second)) }), jwt.ErrTokenUsedBeforeIssued},
+		{"not yet valid", with(func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(skew + time.Second)) }), jwt.ErrTokenNotValidYet},
+		{"alg none", unsigned(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), jwt.ErrTokenSignatureInvalid},
+		{"alg HS256", unsigned(jwt.SigningMethodHS256, []byte("secret")), jwt.ErrTokenSignatureInvalid},
+		{"garbage", "a.b.c", jwt.ErrTokenMalformed},
+	} {
+		claims, err := ParseAccessToken(ctx, tt.token)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want == nil && (claims == nil || claims.Username != "alice") {
+			t.Errorf("%s: got claims %+v", tt.name, claims)
+		}
+	}
+}
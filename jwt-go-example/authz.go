@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// HasRole reports whether the claims carry role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// HasScope reports whether the space separated "scope" claim contains scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// Permissions lists what a request must be allowed to do. The claims
+// need every one of the scopes, and one of the roles if any are given.
+type Permissions struct {
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// allows reports whether claims satisfy p, and if not, the scopes that are missing.
+func (p Permissions) allows(claims *Claims) (bool, []string) {
+	var missing []string
+	for _, scope := range p.Scopes {
+		if !claims.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return false, missing
+	}
+	if len(p.Roles) == 0 {
+		return true, nil
+	}
+	for _, role := range p.Roles {
+		if claims.HasRole(role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorize calls next if the claims stored by RequireAuth satisfy p.
+// Requests without claims get 401, requests lacking permissions get 403.
+func (p Permissions) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jwt-go-example"`)
+			writeError(w, r, http.StatusUnauthorized, "invalid_request", errMissingToken.Error())
+			return
+		}
+		if ok, missing := p.allows(claims); !ok {
+			if len(missing) > 0 {
+				w.Header().Set("WWW-Authenticate",
+					fmt.Sprintf(`Bearer realm="jwt-go-example", error="insufficient_scope", scope=%q`, strings.Join(p.Scopes, " ")))
+				writeError(w, r, http.StatusForbidden, "insufficient_scope", "missing scopes: "+strings.Join(missing, " "))
+				return
+			}
+			writeError(w, r, http.StatusForbidden, "forbidden", "requires one of the roles: "+strings.Join(p.Roles, " "))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireScopes only calls next if the access token has every one of scopes.
+// It must be wrapped by RequireAuth.
+func RequireScopes(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return Permissions{Scopes: scopes}.authorize
+}
+
+// RequireRole only calls next if the access token has one of roles.
+// It must be wrapped by RequireAuth.
+func RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return Permissions{Roles: roles}.authorize
+}
+
+// PolicyRule maps requests to the permissions they need. Method matches
+// every method if empty, and a GET rule also matches HEAD, which the
+// ServeMux routes to the GET handlers. Path is matched exactly, or as a
+// prefix if it ends with a slash.
+type PolicyRule struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path"`
+	Permissions
+}
+
+// Policy is a list of rules, usually read from a JSON file like:
+//
+//	{"rules": [{"path": "/admin/", "roles": ["admin"]},
+//	           {"method": "GET", "path": "/welcome", "scopes": ["profile:read"]}]}
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy reads a Policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range p.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("%s: rule path %q must start with /", path, rule.Path)
+		}
+		p.Rules[i].Method = strings.ToUpper(rule.Method)
+	}
+	return &p, nil
+}
+
+// match returns the rule with the longest path matching r, or nil.
+func (p *Policy) match(r *http.Request) *PolicyRule {
+	var best *PolicyRule
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Method != "" && rule.Method != r.Method && !(rule.Method == http.MethodGet && r.Method == http.MethodHead) {
+			continue
+		}
+		matches := r.URL.Path == rule.Path ||
+			strings.HasSuffix(rule.Path, "/") && strings.HasPrefix(r.URL.Path, rule.Path)
+		if matches && (best == nil || len(rule.Path) > len(best.Path)) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// Handler authenticates and authorizes the requests matched by a rule
+// before passing them to next. Other requests go straight to next.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := p.match(r)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		RequireAuth(rule.Permissions.authorize(next.ServeHTTP))(w, r)
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireScopesAndRole(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	token := func(roles []string, scope string) string {
+		claims := accessClaims(now)
+		claims.Roles, claims.Scope = roles, scope
+		return sign(t, claims)
+	}
+	reader := token([]string{"user"}, "profile:read notes:read")
+	admin := token([]string{"admin"}, "profile:read notes:read notes:write")
+
+	serve := func(h http.HandlerFunc, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	writeNotes := RequireAuth(RequireScopes("notes:read", "notes:write")(Welcome))
+	adminOnly := RequireAuth(RequireRole("admin")(Welcome))
+	for _, tt := range []struct {
+		name      string
+		handler   http.HandlerFunc
+		token     string
+		want      int
+		challenge string
+	}{
+		{"scopes without token", writeNotes, "", http.StatusUnauthorized, `realm="jwt-go-example"`},
+		{"missing scope", writeNotes, reader, http.StatusForbidden, `error="insufficient_scope", scope="notes:read notes:write"`},
+		{"all scopes", writeNotes, admin, http.StatusOK, ""},
+		{"role without token", adminOnly, "", http.StatusUnauthorized, `realm="jwt-go-example"`},
+		{"wrong role", adminOnly, reader, http.StatusForbidden, ""},
+		{"right role", adminOnly, admin, http.StatusOK, ""},
+	} {
+		rec := serve(tt.handler, tt.token)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d %s", tt.name, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); !strings.Contains(got, tt.challenge) || tt.challenge == "" && got != "" {
+			t.Errorf("%s: WWW-Authenticate %q, want %q", tt.name, got, tt.challenge)
+		}
+	}
+
+	// without RequireAuth there are no claims, which is a 401 and not a 403
+	if rec := serve(RequireRole("admin")(Welcome), admin); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no claims in context: got %d", rec.Code)
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(`{"rules": [
+		{"path": "/admin/", "roles": ["admin"]},
+		{"path": "/admin/reports/", "scopes": ["reports:read"]},
+		{"method": "get", "path": "/notes", "scopes": ["notes:read"]},
+		{"path": "/notes", "scopes": ["notes:write"]}
+	]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		method, path string
+		want         string // the scope or role of the matching rule
+	}{
+		{"GET", "/admin/users", "admin"},
+		{"GET", "/admin/reports/daily", "reports:read"},
+		{"GET", "/admin/reports/", "reports:read"},
+		{"GET", "/admin", ""}, // "/admin/" is a prefix, not "/admin"
+		{"GET", "/notes", "notes:read"},
+		{"HEAD", "/notes", "notes:read"},
+		{"POST", "/notes", "notes:write"},
+		{"GET", "/notes/1", ""}, // "/notes" has no slash so it is matched exactly
+		{"GET", "/welcome", ""},
+	} {
+		var got string
+		if rule := policy.match(httptest.NewRequest(tt.method, tt.path, nil)); rule != nil {
+			got = strings.Join(append(rule.Roles, rule.Scopes...), " ")
+		}
+		if got != tt.want {
+			t.Errorf("%s %s: matched %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": [{"path": "admin"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPolicy(path); err == nil {
+		t.Error("LoadPolicy accepted a relative path")
+	}
+}
+
+func TestPolicyHandler(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	claims := accessClaims(now)
+	claims.Roles = []string{"user"}
+	user := sign(t, claims)
+
+	policy := &Policy{Rules: []PolicyRule{
+		{Path: "/admin/", Permissions: Permissions{Roles: []string{"admin"}}},
+		{Method: "GET", Path: "/reports", Permissions: Permissions{Roles: []string{"admin"}}},
+	}}
+	h := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/public", "", http.StatusOK},
+		{"GET", "/admin/users", "", http.StatusUnauthorized},
+		{"GET", "/admin/users", user, http.StatusForbidden},
+		// the ServeMux serves HEAD with the GET handler, so the GET rule applies
+		{"HEAD", "/reports", user, http.StatusForbidden},
+		{"POST", "/reports", user, http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSignupPermissions(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	// a user who signs up gets tokens the notes API accepts, for reading and writing
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+	pair := decode[TokenPair](t, call(t, Signin, creds, ""))
+	claims, err := ParseAccessToken(context.Background(), pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(claims.Roles, " ") != "user" || claims.Scope != "profile:read notes:read notes:write" {
+		t.Errorf("roles %v and scope %q", claims.Roles, claims.Scope)
+	}
+	if rec := call(t, RequireAuth(RequireScopes("notes:read", "notes:write")(Welcome)), nil, pair.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("notes scopes: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+// The names of the cookies set by the server
+const (
+	tokenCookie   = "token"
+	refreshCookie = "refresh_token"
+	csrfCookie    = "csrf_token"
+)
+
+// csrfHeader carries the copy of the CSRF cookie on state-changing requests
+const csrfHeader = "X-CSRF-Token"
+
+// CookieConfig holds the attributes of the cookies set by the server.
+type CookieConfig struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// The cookie attributes, which can be changed with flags in main. Secure
+// cookies are still sent to http://localhost by the browsers
+var cookieConfig = CookieConfig{
+	Path:     "/",
+	Secure:   true,
+	SameSite: http.SameSiteStrictMode,
+}
+
+// ParseSameSite reads a SameSite mode given as strict, lax or none.
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode %q, want strict, lax or none", mode)
+}
+
+// setCookie sets a cookie with the configured attributes, expiring at expires.
+// Only the CSRF cookie is readable by scripts, which must copy it to a header
+func setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cookieConfig.Path,
+		Domain:   cookieConfig.Domain,
+		Expires:  expires,
+		Secure:   cookieConfig.Secure,
+		HttpOnly: name != csrfCookie,
+		SameSite: cookieConfig.SameSite,
+	})
+}
+
+// clearCookie tells the browser to delete the cookie at once. The path and
+// domain must be those it was set with, or the browser keeps it
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     cookieConfig.Path,
+		Domain:   cookieConfig.Domain,
+		MaxAge:   -1,
+		Secure:   cookieConfig.Secure,
+		HttpOnly: name != csrfCookie,
+		SameSite: cookieConfig.SameSite,
+	})
+}
+
+// csrfTokenTTL is the lifetime of the CSRF cookie, as long as a session can last
+const csrfTokenTTL = refreshTokenTTL
+
+// CSRFToken returns the CSRF token of the client, setting a new one in the
+// "csrf_token" cookie if it has none. Browser clients fetch it once and send
+// it in the X-CSRF-Token header of their POST, PUT, PATCH and DELETE requests
+func CSRFToken(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		token = c.Value
+	} else {
+		var err error
+		if token, err = newTokenID(); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+	}
+	setCookie(w, csrfCookie, token, clock().Add(csrfTokenTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"csrf_token": token})
+}
+
+// CSRFProtect refuses the state-changing requests carrying the auth cookies
+// unless the X-CSRF-Token header matches the "csrf_token" cookie. Another site
+// can make the browser send the cookies, but can neither read the CSRF cookie
+// nor set the header. Requests authenticated with the Authorization header
+// alone are not sent by browsers on their own, so they are let through.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !hasCookie(r, tokenCookie) && !hasCookie(r, refreshCookie) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(csrfCookie)
+		header := r.Header.Get(csrfHeader)
+		if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(header)) != 1 {
+			writeError(w, r, http.StatusForbidden, "csrf_failed", "missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasCookie(r *http.Request, name string) bool {
+	c, err := r.Cookie(name)
+	return err == nil && c.Value != ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	reached := false
+	h := CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	// fetch a token, which is also set as a cookie readable by scripts
+	rec := httptest.NewRecorder()
+	CSRFToken(rec, httptest.NewRequest(http.MethodGet, "/csrf", nil))
+	token := decode[map[string]string](t, rec)["csrf_token"]
+	var csrf *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookie {
+			csrf = c
+		}
+	}
+	if token == "" || csrf == nil || csrf.Value != token || csrf.HttpOnly || !csrf.Secure {
+		t.Fatalf("got token %q and cookie %+v", token, csrf)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		header  string
+		want    bool
+	}{
+		{"safe method", http.MethodGet, []*http.Cookie{{Name: tokenCookie, Value: "t"}}, "", true},
+		{"bearer only", http.MethodPost, nil, "", true},
+		{"cookie without token", http.MethodPost, []*http.Cookie{{Name: tokenCookie, Value: "t"}, csrf}, "", false},
+		{"wrong token", http.MethodDelete, []*http.Cookie{{Name: refreshCookie, Value: "r"}, csrf}, "nope", false},
+		{"header without cookie", http.MethodPost, []*http.Cookie{{Name: tokenCookie, Value: "t"}}, token, false},
+		{"matching token", http.MethodPost, []*http.Cookie{{Name: tokenCookie, Value: "t"}, csrf}, token, true},
+	}
+	for _, tt := range tests {
+		reached = false
+		req := httptest.NewRequest(tt.method, "/password", nil)
+		for _, c := range tt.cookies {
+			req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+		}
+		if tt.header != "" {
+			req.Header.Set(csrfHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if reached != tt.want {
+			t.Errorf("%s: reached %v, want %v (status %d)", tt.name, reached, tt.want, rec.Code)
+		}
+		if !tt.want && rec.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d", tt.name, rec.Code)
+		}
+	}
+}
+
+func TestLogoutClearsCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Logout(rec, httptest.NewRequest(http.MethodPost, "/logout", nil))
+	cleared := map[string]bool{}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 && c.Path == cookieConfig.Path {
+			cleared[c.Name] = true
+		}
+	}
+	if !cleared[tokenCookie] || !cleared[refreshCookie] {
+		t.Errorf("cleared %v, header %q", cleared, rec.Header().Values("Set-Cookie"))
+	}
+}
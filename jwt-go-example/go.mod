@@ -2,4 +2,12 @@ module github.com/favtuts/jwt-go-example
 
 go 1.22.4
 
-require github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+require (
+	github.com/favtuts/problem v0.0.0
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/crypto v0.27.0
+)
+
+// The shared problem details package, at the root of the repository
+replace github.com/favtuts/problem => ../problem
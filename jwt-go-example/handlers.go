@@ -2,22 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/favtuts/problem"
 	// import the jwt-go library
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Create the JWT key used to create the signature
-var jwtKey = []byte("my_secret_key")
+// The keys used to sign and verify the JWTs, which are set up in main.
+// Services that only verify tokens fetch the public keys from /.well-known/jwks.json
+var keys *KeySet
 
-// For simplification, we're storing the users information as an in-memory map in our code
-var users = map[string]string{
-	"user1": "password1",
-	"user2": "password2",
-}
+// The users are stored with hashed passwords in a UserStore, which is set up in main
+var users UserStore
 
 // Create a struct to read the username and password from the request body
 type Credentials struct {
@@ -28,7 +29,14 @@ type Credentials struct {
 // Create a struct that will be encoded to a JWT.
 // We add jwt.RegisteredClaims as an embedded type, to provide fields like expiry time
 type Claims struct {
-	Username string `json:"username"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	// Scope is a space separated list, as in OAuth 2.0
+	Scope string `json:"scope,omitempty"`
+	// ClientID is set on the tokens issued to OAuth clients instead of users
+	ClientID string `json:"client_id,omitempty"`
+	// SessionID is set on the tokens issued to users
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -39,156 +47,562 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
 		// If the structure of the body is wrong, return an HTTP error
-		w.WriteHeader(http.StatusBadRequest)
+		writeDecodeError(w, r)
+		return
+	}
+
+	// Refuse to check the password while the username or the client is throttled
+	if throttled(w, r, creds.Username) {
+		return
+	}
+
+	// Get the user and its password hash from the store
+	user, err := users.GetUser(r.Context(), creds.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		problem.Write(w, r, err)
+		return
+	}
+
+	// If the user exists
+	// AND, if the password we received matches its hash, the we can move ahead
+	// if NOT, then we return an "Unauthorized" status.
+	// A hash is compared for unknown users too, so that they take as long to answer
+	if user == nil {
+		CheckPassword(dummyHash, creds.Password)
+	}
+	if user == nil || !CheckPassword(user.PasswordHash, creds.Password) {
+		audit(r, EventSigninFailure, creds.Username, "invalid credentials")
+		recordFailure(r, creds.Username)
+		writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "the username or the password is wrong")
+		return
+	}
+	userThrottle.Reset(userKey(r, user.Username))
+
+	// Users with two-factor authentication get a short-lived token, which they
+	// exchange at /signin/mfa for the token pair by sending a valid code
+	m, err := mfaStore.GetMFA(r.Context(), user.Username)
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		problem.Write(w, r, err)
+		return
+	}
+	if m != nil && m.ConfirmedAt != nil {
+		mfaToken, err := IssueMFAToken(user.Username)
+		if err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		audit(r, EventMFARequired, user.Username, "")
+		writeJSON(w, http.StatusOK, MFARequired{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	// Start a new refresh token family and send the first token pair
+	issueTokens(w, r, user, "")
+}
+
+// The TOTP secrets and recovery codes are stored in a MFAStore, which is set up in main
+var mfaStore MFAStore
+
+// MFARequired is returned by Signin to users with two-factor authentication
+type MFARequired struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// Create a struct to read the second factor from the request body.
+// Either a TOTP code or a recovery code must be sent
+type MFAChallenge struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SigninMFA completes the sign in of users with two-factor authentication
+func SigninMFA(w http.ResponseWriter, r *http.Request) {
+	var challenge MFAChallenge
+	err := json.NewDecoder(r.Body).Decode(&challenge)
+	if err != nil {
+		writeDecodeError(w, r)
+		return
+	}
+	claims, err := ParseMFAToken(challenge.MFAToken)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the mfa token is invalid or has expired")
+		return
+	}
+	// Guessing codes is throttled like guessing passwords
+	if throttled(w, r, claims.Username) {
+		return
+	}
+
+	m, err := mfaStore.GetMFA(r.Context(), claims.Username)
+	if err != nil || m.ConfirmedAt == nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the mfa token is invalid or has expired")
+		return
+	}
+
+	verified := false
+	switch {
+	case challenge.Code != "":
+		// A code is accepted only once, even within its 30 second period
+		if counter, ok := ValidateTOTP(m.Secret, challenge.Code, clock()); ok {
+			verified, err = mfaStore.UseCounter(r.Context(), m.Username, counter)
+		}
+	case challenge.RecoveryCode != "":
+		hash := HashOpaqueToken(normalizeRecoveryCode(challenge.RecoveryCode))
+		verified, err = mfaStore.UseRecoveryCode(r.Context(), m.Username, hash)
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if !verified {
+		audit(r, EventSigninFailure, claims.Username, "invalid mfa code")
+		recordFailure(r, claims.Username)
+		writeError(w, r, http.StatusUnauthorized, "invalid_code", "the code is invalid")
+		return
+	}
+	userThrottle.Reset(userKey(r, claims.Username))
+
+	user, err := users.GetUser(r.Context(), claims.Username)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the user no longer exists")
+		return
+	}
+	issueTokens(w, r, user, "")
+}
+
+// MFAEnrollment is returned when starting a two-factor enrollment. The URI is
+// usually shown as a QR code, to be scanned with an authenticator app
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// EnrollMFA starts the two-factor enrollment of the signed in user.
+// Calling it again before confirming replaces the secret
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = mfaStore.StartEnrollment(r.Context(), claims.Username, secret)
+	if errors.Is(err, ErrMFAEnrolled) {
+		writeError(w, r, http.StatusConflict, "already_enrolled", err.Error())
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, MFAEnrollment{
+		Secret:     b32.EncodeToString(secret),
+		OTPAuthURI: TOTPURI(authConfig.Issuer, claims.Username, secret),
+	})
+}
+
+// ConfirmMFA completes the enrollment with a code from the authenticator app,
+// and returns the recovery codes. They are shown only this once
+func ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	var body struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeDecodeError(w, r)
+		return
+	}
+
+	m, err := mfaStore.GetMFA(r.Context(), claims.Username)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		writeError(w, r, http.StatusConflict, "not_enrolled", err.Error())
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if m.ConfirmedAt != nil {
+		writeError(w, r, http.StatusConflict, "already_enrolled", ErrMFAEnrolled.Error())
+		return
+	}
+	counter, ok := ValidateTOTP(m.Secret, body.Code, clock())
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "invalid_code", "the code is invalid")
 		return
 	}
 
-	// Get the expected password from our in memory map
-	expectedPassword, ok := users[creds.Username]
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = mfaStore.ConfirmEnrollment(r.Context(), claims.Username, counter, hashes)
+	if errors.Is(err, ErrMFAEnrolled) {
+		writeError(w, r, http.StatusConflict, "already_enrolled", err.Error())
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// signupPermissions are given to the users who sign up: they can read their
+// profile, and read and write their notes on the notes API
+var signupPermissions = Permissions{Roles: []string{"user"}, Scopes: []string{"profile:read", "notes:read", "notes:write"}}
+
+// Create the Signup handler, which registers a new user with signupPermissions
+func Signup(w http.ResponseWriter, r *http.Request) {
+	var creds Credentials
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		writeDecodeError(w, r)
+		return
+	}
 
-	// If a password exists for the given user
-	// AND, if it is the same as the password we received, the we can move ahead
-	// if NOT, then we return an "Unauthorized" status
-	if !ok || expectedPassword != creds.Password {
-		w.WriteHeader(http.StatusUnauthorized)
+	var invalid problem.ValidationError
+	if err := ValidateUsername(creds.Username); err != nil {
+		invalid.Add("username", err.Error())
+	}
+	if err := ValidatePasswordStrength(creds.Username, creds.Password); err != nil {
+		invalid.Add("password", err.Error())
+	}
+	if err := invalid.Err(); err != nil {
+		problem.Write(w, r, err)
 		return
 	}
 
+	hash, err := HashPassword(creds.Password)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = users.CreateUser(r.Context(), creds.Username, hash)
+	if err != nil {
+		// ErrUserExists is answered with 409 Conflict
+		problem.Write(w, r, err)
+		return
+	}
+	if err := users.SetPermissions(r.Context(), creds.Username, signupPermissions.Roles, signupPermissions.Scopes); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Create a struct to read a password change from the request body
+type PasswordChange struct {
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+// Create the ChangePassword handler, which is only reached through RequireAuth.
+// The current password must be sent along with the new one, and the other
+// sessions of the user are ended
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	var change PasswordChange
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		writeDecodeError(w, r)
+		return
+	}
+
+	user, err := users.GetUser(r.Context(), claims.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		problem.Write(w, r, err)
+		return
+	}
+	if user == nil || !CheckPassword(user.PasswordHash, change.Password) {
+		audit(r, EventPasswordFailed, claims.Username, "invalid current password")
+		writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "the current password is wrong")
+		return
+	}
+
+	if err := ValidatePasswordStrength(claims.Username, change.NewPassword); err != nil {
+		invalid := problem.ValidationError{}
+		invalid.Add("new_password", err.Error())
+		problem.Write(w, r, &invalid)
+		return
+	}
+	hash, err := HashPassword(change.NewPassword)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if err := users.UpdatePassword(r.Context(), claims.Username, hash); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	// A stolen session must not survive the change, only the current one is kept
+	list, err := sessions.ListSessions(r.Context(), claims.Username)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	ended := 0
+	for _, sess := range list {
+		if sess.ID == claims.SessionID {
+			continue
+		}
+		if err := endSession(r.Context(), sess.ID); err != nil {
+			problem.Write(w, r, err)
+			return
+		}
+		ended++
+	}
+	audit(r, EventPasswordChange, claims.Username, fmt.Sprintf("%d other sessions ended", ended))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Welcome is only reached through RequireAuth, which stores the token's claims in the request context
+func Welcome(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	// Finally, return the welcome message to the user, along with their
+	// username given in the token
+	w.Write([]byte(fmt.Sprintf("Welcome %s!", claims.Username)))
+}
+
+// The lifetime of the access and refresh tokens
+const (
+	accessTokenTTL  = 5 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// The refresh tokens are stored hashed in a RefreshStore, which is set up in main
+var refreshTokens RefreshStore
+
+// TokenPair is returned by the Signin and Refresh handlers
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// issueTokens signs a new access token and stores a new refresh token for the
+// given session, or for a new session if sessionID is empty. The pair is set as
+// the "token" and "refresh_token" cookies and written as the JSON response.
+func issueTokens(w http.ResponseWriter, r *http.Request, user *User, sessionID string) {
+	username := user.Username
+	now := clock()
+	// Every access token gets an ID, so that it can be revoked
+	jti, err := newTokenID()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
 	// Declare the expiration time of the token
 	// here, we have kept it as 5 minutes
-	expirationTime := time.Now().Add(5 * time.Minute)
+	expirationTime := now.Add(accessTokenTTL)
+	refreshExpirationTime := now.Add(refreshTokenTTL)
+
+	// A new session is named after the first access token. The refresh tokens
+	// of the session form the family of that name
+	signin := sessionID == ""
+	if signin {
+		sessionID = jti
+		err = sessions.CreateSession(r.Context(), &Session{
+			ID:         sessionID,
+			Username:   username,
+			Device:     deviceName(r),
+			UserAgent:  r.UserAgent(),
+			IP:         clientIP(r),
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  refreshExpirationTime,
+		})
+	} else {
+		err = sessions.Renew(r.Context(), sessionID, clientIP(r), refreshExpirationTime)
+	}
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		problem.Write(w, r, err)
+		return
+	}
 	// Create the JWT claims, which includes the username and expiry time
 	claims := &Claims{
-		Username: creds.Username,
+		Username:  username,
+		Roles:     user.Roles,
+		Scope:     strings.Join(user.Scopes, " "),
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    authConfig.Issuer,
+			Subject:   username,
+			Audience:  jwt.ClaimStrings{authConfig.Audience},
+			ID:        jti,
 		},
 	}
 
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// Create the JWT string
-	tokenString, err := token.SignedString(jwtKey)
+	// Sign the claims with the current key, whose ID is set as the "kid" header
+	tokenString, err := keys.Sign(claims)
 	if err != nil {
 		// If there is an error in creating the JWT return an internal server error
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Write(w, r, err)
+		return
+	}
+
+	// The refresh token is an opaque random string, only its hash is stored
+	refreshToken, hash, err := NewOpaqueToken()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = refreshTokens.Create(r.Context(), &RefreshToken{
+		Hash:      hash,
+		FamilyID:  sessionID,
+		Username:  username,
+		CreatedAt: now.UTC(),
+		ExpiresAt: refreshExpirationTime.UTC(),
+	})
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
 
 	// Finally, we set the client cookie for "token" as the JWT we just generated
 	// we also set an expiry time which is the same as the token itself
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
+	setCookie(w, tokenCookie, tokenString, expirationTime)
+	setCookie(w, refreshCookie, refreshToken, refreshExpirationTime)
+	writeJSON(w, http.StatusOK, TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
 	})
+	if signin {
+		audit(r, EventSigninSuccess, username, "session "+sessionID)
+	} else {
+		audit(r, EventRefresh, username, "session "+sessionID)
+	}
 }
 
-func Welcome(w http.ResponseWriter, r *http.Request) {
-	// We can obtain the session token from the requests cookies, which come with every request
-	c, err := r.Cookie("token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			// If the cookie is not set, return an unauthorized status
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		// For any other type of error, return a bad request status
-		w.WriteHeader(http.StatusBadRequest)
-		return
+// refreshTokenFromRequest reads the refresh token from the "refresh_token"
+// cookie, or else from a {"refresh_token": "..."} JSON body
+func refreshTokenFromRequest(r *http.Request) string {
+	if c, err := r.Cookie(refreshCookie); err == nil && c.Value != "" {
+		return c.Value
 	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	return body.RefreshToken
+}
 
-	// Get the JWT string from the cookie
-	tknStr := c.Value
-
-	// Initialize a new instance of `Claims`
-	claims := &Claims{}
+// Refresh exchanges a refresh token for a new access/refresh pair. Each refresh
+// token can be used once: presenting a token that was already rotated means it
+// leaked, so the whole session is revoked and the user has to sign in again.
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	presented := refreshTokenFromRequest(r)
+	if presented == "" {
+		writeError(w, r, http.StatusUnauthorized, "invalid_request", "missing refresh token")
+		return
+	}
 
-	// Parse the JWT string and store the result in `claims`.
-	// Note that we are passing the key in this method as well. This method will return an error
-	// if the token is invalid (if it has expired according to the expiry time we set on sign in),
-	// or if the signature does not match
-	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
+	stored, err := refreshTokens.Get(r.Context(), HashOpaqueToken(presented))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the refresh token is invalid")
+		return
+	}
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, r, err)
 		return
 	}
-	if !tkn.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
+	if stored.RevokedAt != nil || clock().After(stored.ExpiresAt) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the refresh token has expired or has been revoked")
 		return
 	}
-	// Finally, return the welcome message to the user, along with their
-	// username given in the token
-	w.Write([]byte(fmt.Sprintf("Welcome %s!", claims.Username)))
-}
 
-func Refresh(w http.ResponseWriter, r *http.Request) {
-	// (BEGIN) The code until this point is the same as the first part of the `Welcome` route
-	c, err := r.Cookie("token")
+	// Mark the token as used. If it was already used, end the session
+	rotated, err := refreshTokens.MarkRotated(r.Context(), stored.Hash)
 	if err != nil {
-		if err == http.ErrNoCookie {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, r, err)
 		return
 	}
-	tknStr := c.Value
-	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			w.WriteHeader(http.StatusUnauthorized)
+	if !rotated {
+		audit(r, EventRefreshReuse, stored.Username, "session "+stored.FamilyID+" revoked")
+		if err := endSession(r.Context(), stored.FamilyID); err != nil {
+			problem.Write(w, r, err)
 			return
 		}
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the refresh token was already used, the session has been revoked")
 		return
 	}
-	if !tkn.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
+
+	// Send a new pair in the same family, with the current permissions of the user
+	user, err := users.GetUser(r.Context(), stored.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_token", "the user no longer exists")
 		return
 	}
-	// (END) The code until this point is the same as the first part of the `Welcome` route
-
-	// We ensure that a new token is not issued until enough time has elapsed
-	// In this case, a new token will only be issued if the old token is within
-	// 30 seconds of expiry. Otherwise, return a bad request status
-	if time.Until(claims.ExpiresAt.Time) > 30*time.Second {
-		w.WriteHeader(http.StatusBadRequest)
+	if err != nil {
+		problem.Write(w, r, err)
 		return
 	}
+	issueTokens(w, r, user, stored.FamilyID)
+}
 
-	// Now, create a new token for the current use, with a renewed expiration time
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+// Logout ends the session server-side and clears the cookies
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if presented := refreshTokenFromRequest(r); presented != "" {
+		stored, err := refreshTokens.Get(r.Context(), HashOpaqueToken(presented))
+		if err == nil {
+			err = endSession(r.Context(), stored.FamilyID)
+			if err == nil {
+				audit(r, EventLogout, stored.Username, "session "+stored.FamilyID)
+			}
+		}
+		if err != nil && !errors.Is(err, ErrRefreshTokenNotFound) {
+			problem.Write(w, r, err)
+			return
+		}
+	}
+
+	// immediately clear the token cookies
+	clearCookie(w, tokenCookie)
+	clearCookie(w, refreshCookie)
+}
+
+// SetPermissions replaces the roles and scopes of the user named in the path.
+// It is only reached by admins, through RequireAuth and RequireRole
+func SetPermissions(w http.ResponseWriter, r *http.Request) {
+	var perms Permissions
+	err := json.NewDecoder(r.Body).Decode(&perms)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeDecodeError(w, r)
 		return
 	}
-
-	// Set the new token as the users `token` cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
+	err = users.SetPermissions(r.Context(), r.PathValue("username"), perms.Roles, perms.Scopes)
+	if err != nil {
+		// ErrUserNotFound is answered with 404 Not Found
+		problem.Write(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
-	// immediately clear the token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Expires: time.Now(),
-	})
+// Unlock lifts the lockout of the username, from every client, or of the client
+// IP named in the path.
+// It is only reached by admins, through RequireAuth and RequireRole
+func Unlock(w http.ResponseWriter, r *http.Request) {
+	admin := ""
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		admin = claims.Username
+	}
+	if username := r.PathValue("username"); username != "" {
+		userThrottle.ResetPrefix(userKeys(username))
+		audit(r, EventUnlock, username, "by "+admin)
+	} else {
+		ipThrottle.Reset(r.PathValue("ip"))
+		audit(r, EventUnlock, "", "client "+r.PathValue("ip")+" by "+admin)
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// jwksMaxAge is how long the verifiers may cache the published keys. A new
+// key must be published for that long before it signs, so that they know it.
+const jwksMaxAge = 5 * time.Minute
+
+// SigningKey is a private key together with the JWT algorithm it signs with.
+// Its ID is sent in the "kid" header of every token it signs.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// NewSigningKey picks the signing method matching the type of private and
+// derives the key ID from a hash of its public key.
+func NewSigningKey(private crypto.Signer) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key of %d bits is too small", k.N.BitLen())
+		}
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, errors.New("unsupported ECDSA curve")
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &SigningKey{
+		ID:      base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Method:  method,
+		Private: private,
+	}, nil
+}
+
+// GenerateSigningKey creates a new key for alg, one of RS256, ES256 or EdDSA.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch alg {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(private)
+}
+
+// LoadSigningKey reads a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var private any
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, private)
+	}
+	return NewSigningKey(signer)
+}
+
+// KeySet holds the active signing keys. The first key signs new tokens,
+// and every key in the set is accepted when verifying a token, so that
+// tokens signed before a rotation stay valid until they expire. The next
+// key is published before it signs, but does not verify anything yet.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+	next *SigningKey
+	// retiredAt is when each key but the first stopped signing
+	retiredAt map[string]time.Time
+	// retention is how long a key verifies tokens once retired, the
+	// lifetime of the tokens it signed, 0 keeping the keys forever
+	retention time.Duration
+}
+
+// NewKeySet returns a set signing with the first of keys. The others are
+// considered retired now.
+func NewKeySet(retention time.Duration, keys ...*SigningKey) *KeySet {
+	ks := &KeySet{keys: keys, retiredAt: map[string]time.Time{}, retention: retention}
+	now := time.Now()
+	for _, key := range keys[min(1, len(keys)):] {
+		ks.retiredAt[key.ID] = now
+	}
+	return ks
+}
+
+// Rotate makes the next key the signing key and publishes next in its
+// place. The first call only publishes next. The previous signing key is
+// retired at now, and the keys retired for longer than the retention are
+// dropped.
+func (ks *KeySet) Rotate(next *SigningKey, now time.Time) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.next != nil {
+		if len(ks.keys) > 0 {
+			ks.retiredAt[ks.keys[0].ID] = now
+		}
+		ks.keys = append([]*SigningKey{ks.next}, ks.keys...)
+	}
+	ks.next = next
+	if ks.retention == 0 {
+		return
+	}
+	kept := ks.keys[:min(1, len(ks.keys))]
+	for _, key := range ks.keys[len(kept):] {
+		if now.Sub(ks.retiredAt[key.ID]) < ks.retention {
+			kept = append(kept, key)
+		} else {
+			delete(ks.retiredAt, key.ID)
+		}
+	}
+	ks.keys = kept
+}
+
+// Sign signs claims with the current signing key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	if len(ks.keys) == 0 {
+		ks.mu.RUnlock()
+		return "", ErrUnknownKey
+	}
+	key := ks.keys[0]
+	ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc looks up the public key named by the token's "kid" header.
+// The token's "alg" must match the algorithm of that key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+		}
+		return key.Private.Public(), nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Algs returns the algorithms of the keys in the set.
+func (ks *KeySet) Algs() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	algs := make([]string, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		algs = append(algs, key.Method.Alg())
+	}
+	return algs
+}
+
+// JWK is the JSON Web Key representation of a public key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys of the set, the next one last.
+func (ks *KeySet) JWKS() []JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	published := ks.keys
+	if ks.next != nil {
+		published = append(published[:len(published):len(published)], ks.next)
+	}
+	jwks := make([]JWK, 0, len(published))
+	for _, key := range published {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(pub)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWKSHandler publishes the public keys of the set at /.well-known/jwks.json.
+func (ks *KeySet) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(map[string][]JWK{"keys": ks.JWKS()})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func generateKey(t *testing.T, alg string) *SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// verify parses token with the keys of ks, accepting every algorithm the
+// example signs with
+func verify(ks *KeySet, token string) error {
+	_, err := jwt.Parse(token, ks.Keyfunc, jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}))
+	return err
+}
+
+func signWith(t *testing.T, ks *KeySet) string {
+	t.Helper()
+	token, err := ks.Sign(jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeySetRotate(t *testing.T) {
+	first, second, third := generateKey(t, "ES256"), generateKey(t, "EdDSA"), generateKey(t, "RS256")
+	ks := NewKeySet(time.Hour, first)
+	old := signWith(t, ks)
+	now := time.Now()
+	kid := func(token string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(parsed.Header["kid"])
+	}
+
+	// the next key is published before it signs
+	ks.Rotate(second, now)
+	if kid(signWith(t, ks)) != first.ID {
+		t.Error("the next key signs as soon as it is published")
+	}
+	if published := ks.JWKS(); len(published) != 2 || published[1].Kid != second.ID {
+		t.Errorf("published keys: got %+v", published)
+	}
+
+	// after a rotation, the new key signs and the retired one still verifies
+	now = now.Add(10 * time.Minute)
+	ks.Rotate(third, now)
+	current := signWith(t, ks)
+	if kid(current) != second.ID {
+		t.Fatalf("token signed after the rotation: got kid %s", kid(current))
+	}
+	if err := verify(ks, old); err != nil {
+		t.Errorf("token of the retired key during the overlap: %v", err)
+	}
+	if fmt.Sprint(ks.Algs()) != "[EdDSA ES256]" {
+		t.Errorf("algs: got %v", ks.Algs())
+	}
+
+	// however often the keys rotate, a retired key is kept for the retention
+	now = now.Add(50 * time.Minute)
+	ks.Rotate(generateKey(t, "EdDSA"), now)
+	if err := verify(ks, old); err != nil {
+		t.Errorf("token of a key retired 50 minutes ago: %v", err)
+	}
+	now = now.Add(10 * time.Minute)
+	ks.Rotate(generateKey(t, "EdDSA"), now)
+	if err := verify(ks, old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of a key retired an hour ago: got %v", err)
+	}
+	if err := verify(ks, current); err != nil {
+		t.Errorf("token of the previous key: %v", err)
+	}
+	if len(ks.JWKS()) != 4 {
+		t.Errorf("got %d published keys, want 4", len(ks.JWKS()))
+	}
+}
+
+func TestKeyfuncRejections(t *testing.T) {
+	ecKey, rsaKey := generateKey(t, "ES256"), generateKey(t, "RS256")
+	ks := NewKeySet(0, ecKey)
+	claims := jwt.RegisteredClaims{Subject: "alice"}
+
+	// a token naming the EC key but signed with RS256, by another key
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ecKey.ID
+	mismatched, _ := token.SignedString(rsaKey.Private)
+	if err := verify(ks, mismatched); err == nil {
+		t.Error("a token with the alg of another key was accepted")
+	}
+
+	for name, kid := range map[string]any{"unknown kid": rsaKey.ID, "no kid": nil, "kid of another type": 42} {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		s, _ := token.SignedString(rsaKey.Private)
+		if err := verify(ks, s); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	rsaKey, ecKey, edKey := generateKey(t, "RS256"), generateKey(t, "ES256"), generateKey(t, "EdDSA")
+	ks := NewKeySet(0, rsaKey, ecKey, edKey)
+	rec := httptest.NewRecorder()
+	ks.JWKSHandler(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Fatalf("got %d %v", rec.Code, rec.Header())
+	}
+	var set struct{ Keys []JWK }
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil || len(set.Keys) != 3 {
+		t.Fatalf("got %+v, %v", set, err)
+	}
+	// the private parts are never published
+	var raw struct{ Keys []map[string]any }
+	json.Unmarshal(rec.Body.Bytes(), &raw)
+	for _, k := range raw.Keys {
+		if _, ok := k["d"]; ok {
+			t.Errorf("a private key is published: %v", k)
+		}
+	}
+
+	b64int := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	r, e, o := set.Keys[0], set.Keys[1], set.Keys[2]
+	rsaPub := rsaKey.Private.Public().(*rsa.PublicKey)
+	if r.Kty != "RSA" || r.Kid != rsaKey.ID || r.Alg != "RS256" || r.Use != "sig" ||
+		b64int(r.N).Cmp(rsaPub.N) != 0 || b64int(r.E).Int64() != int64(rsaPub.E) {
+		t.Errorf("RSA key: got %+v", r)
+	}
+	ecPub := ecKey.Private.Public().(*ecdsa.PublicKey)
+	if e.Kty != "EC" || e.Crv != "P-256" || e.Alg != "ES256" || len(e.X) != 43 ||
+		b64int(e.X).Cmp(ecPub.X) != 0 || b64int(e.Y).Cmp(ecPub.Y) != 0 {
+		t.Errorf("EC key: got %+v", e)
+	}
+	edPub := edKey.Private.Public().(ed25519.PublicKey)
+	if x, _ := base64.RawURLEncoding.DecodeString(o.X); o.Kty != "OKP" || o.Crv != "Ed25519" || o.Alg != "EdDSA" || !edPub.Equal(ed25519.PublicKey(x)) {
+		t.Errorf("Ed25519 key: got %+v", o)
+	}
+}
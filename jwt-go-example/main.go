@@ -1,17 +1,238 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
 )
 
+// The demo users that are created on the first start
+var seedUsers = map[string]struct {
+	password string
+	Permissions
+}{
+	"user1": {"password1", Permissions{Roles: []string{"admin"}, Scopes: []string{"profile:read", "notes:read", "notes:write"}}},
+	"user2": {"password2", Permissions{Roles: []string{"user"}, Scopes: []string{"profile:read", "notes:read"}}},
+}
+
 func main() {
+	// "jwt-go-example audit ..." reads the audit log instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := runAuditCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	dbPath := flag.String("db", "users.db", "path to the SQLite users database")
+	keyFiles := flag.String("keys", "", "comma separated PEM private keys, the first one signs new tokens")
+	alg := flag.String("alg", "ES256", "algorithm of the generated keys when -keys is empty: RS256, ES256 or EdDSA")
+	rotateEvery := flag.Duration("rotate", 0, "generate a new signing key at this interval, 0 disables rotation")
+	flag.StringVar(&authConfig.Issuer, "issuer", authConfig.Issuer, "issuer of the access tokens")
+	flag.StringVar(&authConfig.Audience, "audience", authConfig.Audience, "audience of the access tokens")
+	policyFile := flag.String("policy", "", "JSON file mapping routes to the roles and scopes they require")
+	flag.DurationVar(&authConfig.ClockSkew, "clock-skew", authConfig.ClockSkew, "leeway when checking the time claims of access tokens")
+	flag.IntVar(&userThrottle.cfg.MaxFailures, "max-failures", userThrottle.cfg.MaxFailures, "failed sign ins before a username is locked out from a client")
+	flag.DurationVar(&userThrottle.cfg.LockoutDuration, "lockout", userThrottle.cfg.LockoutDuration, "how long a username stays locked out from a client")
+	auditLog := flag.String("audit-log", "audit.log", "file the authentication events are appended to, as JSON lines")
+	auditMaxSize := flag.Int64("audit-max-size", 10<<20, "size in bytes at which the audit log is rotated")
+	auditBackups := flag.Int("audit-backups", 5, "number of rotated audit logs kept, at least 1")
+	flag.StringVar(&cookieConfig.Path, "cookie-path", cookieConfig.Path, "path of the auth cookies")
+	flag.StringVar(&cookieConfig.Domain, "cookie-domain", cookieConfig.Domain, "domain of the auth cookies, empty for the host only")
+	flag.BoolVar(&cookieConfig.Secure, "cookie-secure", cookieConfig.Secure, "send the auth cookies over HTTPS only")
+	sameSite := flag.String("cookie-samesite", "strict", "SameSite mode of the auth cookies: strict, lax or none")
+	flag.Parse()
+
+	var err error
+	cookieConfig.SameSite, err = ParseSameSite(*sameSite)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cookieConfig.SameSite == http.SameSiteNoneMode && !cookieConfig.Secure {
+		log.Fatal("SameSite=None cookies must be secure")
+	}
+
+	sink, err := NewFileSink(*auditLog, *auditMaxSize, *auditBackups)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
+	auditSink = sink
+
+	keys, err = loadKeys(*keyFiles, *alg, keyRetention())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *rotateEvery > 0 {
+		if *rotateEvery < jwksMaxAge {
+			log.Fatalf("-rotate must be at least %v, the time the next key is published before it signs", jwksMaxAge)
+		}
+		if err := rotateKeys(keys, *alg, *rotateEvery); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewSQLiteUserStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := seed(context.Background(), store); err != nil {
+		log.Fatal(err)
+	}
+	users = store
+	refreshTokens, err = NewSQLiteRefreshStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mfaStore, err = NewSQLiteMFAStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	revocationStore, err := NewSQLiteRevocationStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	denylist, err := NewCachedRevocations(context.Background(), revocationStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go denylist.ReloadEvery(time.Minute)
+	revocations = denylist
+	sessions, err = NewSQLiteSessionStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clients, err = NewSQLiteClientStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// we will implement these handlers
 	http.HandleFunc("/signin", Signin)
-	http.HandleFunc("/welcome", Welcome)
+	http.HandleFunc("/signin/mfa", SigninMFA)
+	http.HandleFunc("/mfa/enroll", RequireAuth(EnrollMFA))
+	http.HandleFunc("/mfa/confirm", RequireAuth(ConfirmMFA))
+	http.HandleFunc("/signup", Signup)
+	http.HandleFunc("/password", RequireAuth(ChangePassword))
+	http.HandleFunc("/welcome", RequireAuth(Welcome))
 	http.HandleFunc("/refresh", Refresh)
+	http.HandleFunc("GET /sessions", RequireAuth(ListSessions))
+	http.HandleFunc("DELETE /sessions/{id}", RequireAuth(DeleteSession))
 	http.HandleFunc("/logout", Logout)
+	http.HandleFunc("GET /csrf", CSRFToken)
+	http.HandleFunc("/.well-known/jwks.json", keys.JWKSHandler)
+	http.HandleFunc("/oauth/token", OAuthToken)
+	http.HandleFunc("/oauth/introspect", OAuthIntrospect)
+	http.HandleFunc("/oauth/revoke", OAuthRevoke)
+	http.HandleFunc("POST /admin/clients", RequireAuth(RequireRole("admin")(RegisterClient)))
+	http.HandleFunc("PUT /admin/users/{username}/permissions", RequireAuth(RequireRole("admin")(SetPermissions)))
+	http.HandleFunc("DELETE /admin/users/{username}/lockout", RequireAuth(RequireRole("admin")(Unlock)))
+	http.HandleFunc("DELETE /admin/ips/{ip}/lockout", RequireAuth(RequireRole("admin")(Unlock)))
+
+	// the routes listed in the policy file also need the permissions given there
+	var handler http.Handler = http.DefaultServeMux
+	if *policyFile != "" {
+		policy, err := LoadPolicy(*policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler = policy.Handler(handler)
+	}
+	// state-changing requests made with the auth cookies need the CSRF token
+	handler = CSRFProtect(handler)
+	// outermost, so that every answer, the errors included, has a trace id
+	handler = problem.Middleware(handler)
 
 	// start the server on port 8080
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", handler))
+}
+
+// seed creates the demo users unless they already exist
+func seed(ctx context.Context, store UserStore) error {
+	for username, seed := range seedUsers {
+		if _, err := store.GetUser(ctx, username); err == nil {
+			continue
+		}
+		hash, err := HashPassword(seed.password)
+		if err != nil {
+			return err
+		}
+		err = store.CreateUser(ctx, username, hash)
+		if err != nil && !errors.Is(err, ErrUserExists) {
+			return err
+		}
+		err = store.SetPermissions(ctx, username, seed.Roles, seed.Scopes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyRetention is how long a retired key verifies tokens: the lifetime of the
+// longest lived tokens it signed, plus the clock skew allowed on their expiry
+func keyRetention() time.Duration {
+	return max(accessTokenTTL, clientTokenTTL, mfaTokenTTL) + authConfig.ClockSkew
+}
+
+// loadKeys reads the keys from the PEM files, or generates one if there are none
+func loadKeys(files, alg string, retention time.Duration) (*KeySet, error) {
+	if files == "" {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Generated %s signing key %s", key.Method.Alg(), key.ID)
+		return NewKeySet(retention, key), nil
+	}
+
+	var loaded []*SigningKey
+	for _, path := range strings.Split(files, ",") {
+		key, err := LoadSigningKey(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %s signing key %s from %s", key.Method.Alg(), key.ID, path)
+		loaded = append(loaded, key)
+	}
+	return NewKeySet(retention, loaded...), nil
+}
+
+// rotateKeys publishes a freshly generated next key, then in the background
+// makes it the signing key at every interval and publishes another one, so
+// that every key is published for an interval before it signs
+func rotateKeys(ks *KeySet, alg string, interval time.Duration) error {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		return err
+	}
+	ks.Rotate(key, time.Now())
+	log.Printf("Published the next %s signing key %s", key.Method.Alg(), key.ID)
+	go func() {
+		for now := range time.Tick(interval) {
+			next, err := GenerateSigningKey(alg)
+			if err != nil {
+				log.Println("Key rotation failed:", err)
+				continue
+			}
+			ks.Rotate(next, now)
+			log.Printf("Rotated to signing key %s, published the next %s signing key %s", key.ID, next.Method.Alg(), next.ID)
+			key = next
+		}
+	}()
+	return nil
 }
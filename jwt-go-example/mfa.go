@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults of authenticator apps.
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew is the number of periods accepted before and after the current one
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFANotEnrolled = problem.New(problem.ErrConflict, "two-factor authentication is not enrolled")
+	ErrMFAEnrolled    = problem.New(problem.ErrConflict, "two-factor authentication is already enrolled")
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160-bit secret, as recommended for HMAC-SHA1.
+func GenerateTOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps read from a QR code.
+func TOTPURI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", b32.EncodeToString(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// hotp computes the HOTP value of counter (RFC 4226).
+func hotp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(totpPeriod.Seconds())
+}
+
+// TOTPCode returns the code of secret at time t.
+func TOTPCode(secret []byte, t time.Time) string {
+	return hotp(secret, totpCounter(t))
+}
+
+// ValidateTOTP checks code against the periods around t, and returns the
+// counter of the matching period so that callers can refuse to accept the
+// same code twice.
+func ValidateTOTP(secret []byte, code string, t time.Time) (uint64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	now := totpCounter(t)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := now + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns one-time codes like "k3vq-7mzp" and the hashes to store for them.
+func GenerateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(b32.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashOpaqueToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts the codes with any case and with or without the dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// MFA is the two-factor authentication state of a user.
+type MFA struct {
+	Username string
+	Secret   []byte
+	// ConfirmedAt is nil until the user proved the enrollment with a valid code
+	ConfirmedAt *time.Time
+	// LastCounter is the TOTP period of the last accepted code
+	LastCounter uint64
+}
+
+// MFAStore persists TOTP secrets and recovery codes.
+type MFAStore interface {
+	// GetMFA returns ErrMFANotEnrolled if the user never started an enrollment.
+	GetMFA(ctx context.Context, username string) (*MFA, error)
+	// StartEnrollment stores a new unconfirmed secret. It returns
+	// ErrMFAEnrolled if the user has already confirmed an enrollment.
+	StartEnrollment(ctx context.Context, username string, secret []byte) error
+	// ConfirmEnrollment marks the enrollment as confirmed and replaces the recovery codes.
+	ConfirmEnrollment(ctx context.Context, username string, counter uint64, recoveryHashes []string) error
+	// UseCounter records an accepted TOTP code. It reports false if a code of
+	// this period or a later one was already accepted.
+	UseCounter(ctx context.Context, username string, counter uint64) (bool, error)
+	// UseRecoveryCode consumes a recovery code. It reports false if the code
+	// does not exist or was already used.
+	UseRecoveryCode(ctx context.Context, username, hash string) (bool, error)
+}
+
+// SQLiteMFAStore is a MFAStore backed by a SQLite database.
+type SQLiteMFAStore struct {
+	db *sql.DB
+}
+
+const createMFATables = `
+CREATE TABLE IF NOT EXISTS user_mfa (
+  username VARCHAR(32) PRIMARY KEY,
+  secret BLOB NOT NULL,
+  confirmed_at TIMESTAMP,
+  last_counter INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  username VARCHAR(32) NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  used_at TIMESTAMP,
+  PRIMARY KEY (username, code_hash)
+);
+`
+
+// NewSQLiteMFAStore creates the MFA tables if needed and returns a store using db.
+func NewSQLiteMFAStore(db *sql.DB) (*SQLiteMFAStore, error) {
+	if _, err := db.Exec(createMFATables); err != nil {
+		return nil, err
+	}
+	return &SQLiteMFAStore{db: db}, nil
+}
+
+func (s *SQLiteMFAStore) GetMFA(ctx context.Context, username string) (*MFA, error) {
+	var m MFA
+	var confirmedAt sql.NullTime
+	var lastCounter int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT username, secret, confirmed_at, last_counter FROM user_mfa WHERE username = ?", username).
+		Scan(&m.Username, &m.Secret, &confirmedAt, &lastCounter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if confirmedAt.Valid {
+		m.ConfirmedAt = &confirmedAt.Time
+	}
+	m.LastCounter = uint64(lastCounter)
+	return &m, nil
+}
+
+func (s *SQLiteMFAStore) StartEnrollment(ctx context.Context, username string, secret []byte) error {
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO user_mfa (username, secret) VALUES (?, ?)
+ON CONFLICT(username) DO UPDATE SET secret = excluded.secret, last_counter = 0
+WHERE user_mfa.confirmed_at IS NULL`, username, secret)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAEnrolled
+	}
+	return nil
+}
+
+func (s *SQLiteMFAStore) ConfirmEnrollment(ctx context.Context, username string, counter uint64, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE user_mfa SET confirmed_at = ?, last_counter = ? WHERE username = ? AND confirmed_at IS NULL",
+		clock().UTC(), int64(counter), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMFAEnrolled
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE username = ?", username); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (username, code_hash) VALUES (?, ?)", username, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteMFAStore) UseCounter(ctx context.Context, username string, counter uint64) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE user_mfa SET last_counter = ? WHERE username = ? AND last_counter < ?",
+		int64(counter), username, int64(counter))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLiteMFAStore) UseRecoveryCode(ctx context.Context, username, hash string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = ? WHERE username = ? AND code_hash = ? AND used_at IS NULL",
+		clock().UTC(), username, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, appendix B, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := TOTPCode(secret, time.Unix(tt.unix, 0)); got != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	for _, offset := range []time.Duration{-totpPeriod, 0, totpPeriod} {
+		if _, ok := ValidateTOTP(secret, TOTPCode(secret, now.Add(offset)), now); !ok {
+			t.Errorf("code from %v away was rejected", offset)
+		}
+	}
+	if _, ok := ValidateTOTP(secret, TOTPCode(secret, now.Add(3*totpPeriod)), now); ok {
+		t.Error("code from 3 periods away was accepted")
+	}
+}
+
+// setupTestStores points the package globals at an in-memory database and a
+// clock fixed at *now, and restores them when the test ends.
+func setupTestStores(t *testing.T, now *time.Time) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	oldUsers, oldRefresh, oldMFA, oldKeys, oldClock := users, refreshTokens, mfaStore, keys, clock
+	oldUserThrottle, oldIPThrottle, oldRevocations, oldClients := userThrottle, ipThrottle, revocations, clients
+	oldSessions := sessions
+	t.Cleanup(func() {
+		users, refreshTokens, mfaStore, keys, clock = oldUsers, oldRefresh, oldMFA, oldKeys, oldClock
+		userThrottle, ipThrottle, revocations, clients = oldUserThrottle, oldIPThrottle, oldRevocations, oldClients
+		sessions = oldSessions
+	})
+
+	clock = func() time.Time { return *now }
+	userThrottle = NewLoginThrottle(oldUserThrottle.cfg)
+	ipThrottle = NewLoginThrottle(oldIPThrottle.cfg)
+	key, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = NewKeySet(0, key)
+	if users, err = NewSQLiteUserStore(db); err != nil {
+		t.Fatal(err)
+	}
+	if refreshTokens, err = NewSQLiteRefreshStore(db); err != nil {
+		t.Fatal(err)
+	}
+	if mfaStore, err = NewSQLiteMFAStore(db); err != nil {
+		t.Fatal(err)
+	}
+	revocationStore, err := NewSQLiteRevocationStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revocations, err = NewCachedRevocations(context.Background(), revocationStore); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err = NewSQLiteSessionStore(db); err != nil {
+		t.Fatal(err)
+	}
+	if clients, err = NewSQLiteClientStore(db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func call(t *testing.T, h http.HandlerFunc, body any, accessToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func TestSigninWithMFA(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+	pair := decode[TokenPair](t, call(t, Signin, creds, ""))
+
+	// enroll and confirm with the code of the current period
+	enrollment := decode[MFAEnrollment](t, call(t, RequireAuth(EnrollMFA), nil, pair.AccessToken))
+	secret, err := b32.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := call(t, RequireAuth(ConfirmMFA), map[string]string{"code": TOTPCode(secret, now)}, pair.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm: got %d %s", rec.Code, rec.Body)
+	}
+	recovery := decode[map[string][]string](t, rec)["recovery_codes"]
+	if len(recovery) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(recovery))
+	}
+
+	// the password alone now only gives an mfa token, which is no access token
+	pending := decode[MFARequired](t, call(t, Signin, creds, ""))
+	if !pending.MFARequired || pending.MFAToken == "" {
+		t.Fatalf("signin did not require mfa: %+v", pending)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, pending.MFAToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("mfa token accepted as access token: got %d", rec.Code)
+	}
+
+	// the code used for the enrollment cannot be replayed
+	challenge := MFAChallenge{MFAToken: pending.MFAToken, Code: TOTPCode(secret, now)}
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: got %d", rec.Code)
+	}
+
+	// the code of the next period is accepted once
+	now = now.Add(totpPeriod)
+	challenge.Code = TOTPCode(secret, now)
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusOK {
+		t.Errorf("valid code: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused code: got %d", rec.Code)
+	}
+
+	// recovery codes work once, once the backoff after the failure has elapsed
+	if rec := call(t, SigninMFA, MFAChallenge{MFAToken: pending.MFAToken, RecoveryCode: recovery[0]}, ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("attempt during backoff: got %d", rec.Code)
+	}
+	now = now.Add(userThrottle.cfg.BaseDelay)
+	challenge = MFAChallenge{MFAToken: pending.MFAToken, RecoveryCode: recovery[0]}
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusOK {
+		t.Errorf("recovery code: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: got %d", rec.Code)
+	}
+
+	// the mfa token expires
+	now = now.Add(mfaTokenTTL + authConfig.ClockSkew + time.Second)
+	challenge = MFAChallenge{MFAToken: pending.MFAToken, Code: TOTPCode(secret, now)}
+	if rec := call(t, SigninMFA, challenge, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired mfa token: got %d", rec.Code)
+	}
+}
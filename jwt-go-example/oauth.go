@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrClientNotFound = problem.New(problem.ErrNotFound, "client not found")
+	ErrClientExists   = problem.New(problem.ErrConflict, "client already exists")
+)
+
+// Client is an OAuth 2.0 client, such as a service calling another one.
+// Only the bcrypt hash of its secret is stored.
+type Client struct {
+	ID         string
+	Name       string
+	SecretHash []byte
+	// Scopes are the most a client may request
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+// ClientStore persists the registered OAuth 2.0 clients.
+type ClientStore interface {
+	// GetClient returns ErrClientNotFound if no client has the given ID.
+	GetClient(ctx context.Context, id string) (*Client, error)
+	// CreateClient returns ErrClientExists if the ID is already taken.
+	CreateClient(ctx context.Context, client *Client) error
+}
+
+// SQLiteClientStore is a ClientStore backed by a SQLite database.
+type SQLiteClientStore struct {
+	db *sql.DB
+}
+
+const createClientsTable = `
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id VARCHAR(32) PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  secret_hash BLOB NOT NULL,
+  scopes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL
+)
+`
+
+// NewSQLiteClientStore creates the oauth_clients table if needed and returns a store using db.
+func NewSQLiteClientStore(db *sql.DB) (*SQLiteClientStore, error) {
+	if _, err := db.Exec(createClientsTable); err != nil {
+		return nil, err
+	}
+	return &SQLiteClientStore{db: db}, nil
+}
+
+func (s *SQLiteClientStore) GetClient(ctx context.Context, id string) (*Client, error) {
+	var c Client
+	var scopes string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, secret_hash, scopes, created_at FROM oauth_clients WHERE id = ?", id).
+		Scan(&c.ID, &c.Name, &c.SecretHash, &scopes, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Scopes = strings.Fields(scopes)
+	return &c, nil
+}
+
+func (s *SQLiteClientStore) CreateClient(ctx context.Context, c *Client) error {
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO oauth_clients (id, name, secret_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING",
+		c.ID, c.Name, c.SecretHash, strings.Join(c.Scopes, " "), c.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrClientExists
+	}
+	return nil
+}
+
+// The registered OAuth clients are stored in a ClientStore, which is set up in main
+var clients ClientStore
+
+// clientTokenTTL is the lifetime of the access tokens issued to clients
+const clientTokenTTL = time.Hour
+
+// writeOAuthError answers with an error of RFC 6749, section 5.2
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jwt-go-example"`)
+	}
+	writeJSON(w, status, ErrorResponse{Error: code, ErrorDescription: description})
+}
+
+// authenticateClient checks the client credentials sent with HTTP Basic
+// authentication, or else as the client_id and client_secret form values.
+// A hash is compared for unknown clients too, so that they take as long to answer.
+func authenticateClient(r *http.Request) (*Client, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client, err := clients.GetClient(r.Context(), id)
+	if errors.Is(err, ErrClientNotFound) {
+		CheckPassword(dummyHash, secret)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !CheckPassword(client.SecretHash, secret) {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
+
+// clientAuthFailed answers the errors of authenticateClient
+func clientAuthFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrClientNotFound) {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+}
+
+// OAuthToken is the /oauth/token endpoint. It only supports the
+// client_credentials grant (RFC 6749, section 4.4), whose access tokens are
+// signed with the same keys and carry the same claims as the users' ones.
+func OAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	client, err := authenticateClient(r)
+	if err != nil {
+		clientAuthFailed(w, err)
+		return
+	}
+	if grant := r.PostFormValue("grant_type"); grant != "client_credentials" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+
+	// The client gets the scopes it asks for, or all of its scopes
+	scopes := client.Scopes
+	if requested := strings.Fields(r.PostFormValue("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(client.Scopes, scope) {
+				writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "scope not allowed: "+scope)
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	now := clock()
+	jti, err := newTokenID()
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	tokenString, err := keys.Sign(&Claims{
+		Scope:    strings.Join(scopes, " "),
+		ClientID: client.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(clientTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    authConfig.Issuer,
+			Subject:   client.ID,
+			Audience:  jwt.ClaimStrings{authConfig.Audience},
+			ID:        jti,
+		},
+	})
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": tokenString,
+		"token_type":   "Bearer",
+		"expires_in":   int(clientTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// Introspection is the response of /oauth/introspect (RFC 7662, section 2.2)
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// introspect describes an access token or a refresh token
+func introspect(ctx context.Context, token string) (Introspection, error) {
+	claims, err := ParseAccessToken(ctx, token)
+	if errors.Is(err, errRevocationCheck) {
+		return Introspection{}, err
+	}
+	if err == nil {
+		in := Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Username:  claims.Username,
+			TokenType: "Bearer",
+			Sub:       claims.Subject,
+			Aud:       authConfig.Audience,
+			Iss:       claims.Issuer,
+			Jti:       claims.ID,
+			Exp:       claims.ExpiresAt.Unix(),
+		}
+		if claims.IssuedAt != nil {
+			in.Iat = claims.IssuedAt.Unix()
+		}
+		return in, nil
+	}
+
+	stored, err := refreshTokens.Get(ctx, HashOpaqueToken(token))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return Introspection{}, nil
+	}
+	if err != nil {
+		return Introspection{}, err
+	}
+	if stored.RotatedAt != nil || stored.RevokedAt != nil || clock().After(stored.ExpiresAt) {
+		return Introspection{}, nil
+	}
+	return Introspection{
+		Active:    true,
+		Username:  stored.Username,
+		TokenType: "refresh_token",
+		Sub:       stored.Username,
+		Iss:       authConfig.Issuer,
+		Exp:       stored.ExpiresAt.Unix(),
+		Iat:       stored.CreatedAt.Unix(),
+	}, nil
+}
+
+// OAuthIntrospect is the /oauth/introspect endpoint (RFC 7662). Only
+// registered clients may call it. Invalid, expired and revoked tokens are
+// all described as {"active": false}.
+func OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	if _, err := authenticateClient(r); err != nil {
+		clientAuthFailed(w, err)
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+	in, err := introspect(r.Context(), token)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, in)
+}
+
+// OAuthRevoke is the /oauth/revoke endpoint (RFC 7009). A client may only
+// revoke the tokens issued to it. As the RFC requires, unknown and invalid
+// tokens are answered with 200 OK too.
+func OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	client, err := authenticateClient(r)
+	if err != nil {
+		clientAuthFailed(w, err)
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	// An expired access token needs no revocation, so parseToken is enough
+	if claims, err := parseToken(token, authConfig.Audience); err == nil {
+		if claims.ClientID == client.ID && claims.ID != "" {
+			err = revocations.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time)
+		}
+		if err != nil {
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// NewClientSecret returns a random client ID and secret.
+func NewClientSecret() (id, secret string, err error) {
+	b := make([]byte, 12+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:12]), base64.RawURLEncoding.EncodeToString(b[12:]), nil
+}
+
+// RegisterClient creates a client from {"name": "...", "scopes": [...]} and
+// returns its credentials. The secret is shown only this once.
+// It is only reached by admins, through RequireAuth and RequireRole
+func RegisterClient(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeDecodeError(w, r)
+		return
+	}
+	if body.Name == "" {
+		invalid := problem.ValidationError{}
+		invalid.Add("name", "is required")
+		problem.Write(w, r, &invalid)
+		return
+	}
+
+	id, secret, err := NewClientSecret()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	hash, err := HashPassword(secret)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	err = clients.CreateClient(r.Context(), &Client{
+		ID:         id,
+		Name:       body.Name,
+		SecretHash: hash,
+		Scopes:     body.Scopes,
+		CreatedAt:  clock().UTC(),
+	})
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"client_id":     id,
+		"client_secret": secret,
+		"name":          body.Name,
+		"scopes":        body.Scopes,
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postForm(t *testing.T, h http.HandlerFunc, clientID, secret string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestClientCredentials(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clients.CreateClient(context.Background(), &Client{
+		ID: "reports", Name: "Reports", SecretHash: hash, Scopes: []string{"notes:read", "notes:write"}, CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := url.Values{"grant_type": {"client_credentials"}, "scope": {"notes:read"}}
+	if rec := postForm(t, OAuthToken, "reports", "wrong", grant); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got %d", rec.Code)
+	}
+	if rec := postForm(t, OAuthToken, "reports", "s3cret", url.Values{"grant_type": {"client_credentials"}, "scope": {"admin"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid scope: got %d", rec.Code)
+	}
+	if rec := postForm(t, OAuthToken, "reports", "s3cret", url.Values{"grant_type": {"password"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported grant: got %d", rec.Code)
+	}
+
+	rec := postForm(t, OAuthToken, "reports", "s3cret", grant)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token: got %d %s", rec.Code, rec.Body)
+	}
+	token := decode[map[string]any](t, rec)["access_token"].(string)
+
+	// a client token acts for no user, so the user endpoints refuse it
+	for name, h := range map[string]http.HandlerFunc{"/mfa/enroll": EnrollMFA, "/sessions": ListSessions, "/welcome": Welcome} {
+		if rec := call(t, RequireAuth(h), nil, token); rec.Code != http.StatusForbidden {
+			t.Errorf("%s with a client token: got %d %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	in := decode[Introspection](t, postForm(t, OAuthIntrospect, "reports", "s3cret", url.Values{"token": {token}}))
+	if !in.Active || in.ClientID != "reports" || in.Scope != "notes:read" {
+		t.Errorf("introspection of a valid token: %+v", in)
+	}
+
+	if rec := postForm(t, OAuthRevoke, "reports", "s3cret", url.Values{"token": {token}}); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: got %d", rec.Code)
+	}
+	in = decode[Introspection](t, postForm(t, OAuthIntrospect, "reports", "s3cret", url.Values{"token": {token}}))
+	if in.Active {
+		t.Errorf("introspection of a revoked token: %+v", in)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token accepted by RequireAuth: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt only uses the first 72 bytes of a password
+	maxPasswordLength = 72
+)
+
+var (
+	ErrWeakPassword    = errors.New("password must be 8 to 72 bytes and mix at least three of: lowercase, uppercase, digits, symbols")
+	ErrPasswordHasName = errors.New("password must not contain the username")
+	ErrInvalidUsername = errors.New("username must be 3 to 32 letters, digits, '.', '_' or '-'")
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,32}$`)
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// CheckPassword reports whether password matches hash.
+// bcrypt compares the hashes in constant time.
+func CheckPassword(hash []byte, password string) bool {
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// ValidateUsername checks that username is safe to store and display.
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+// ValidatePasswordStrength rejects passwords that are too short, too long,
+// built from fewer than three character classes, or contain the username.
+func ValidatePasswordStrength(username, password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrWeakPassword
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return ErrWeakPassword
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return ErrPasswordHasName
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("Str0ng-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(hash, []byte("Str0ng-pass")) {
+		t.Fatal("the hash holds the password")
+	}
+	other, _ := HashPassword("Str0ng-pass")
+	if bytes.Equal(hash, other) {
+		t.Error("two hashes of the same password are equal, they are not salted")
+	}
+	for _, tt := range []struct {
+		password string
+		want     bool
+	}{
+		{"Str0ng-pass", true},
+		{"str0ng-pass", false},
+		{"Str0ng-pass ", false},
+		{"", false},
+	} {
+		if got := CheckPassword(hash, tt.password); got != tt.want {
+			t.Errorf("CheckPassword(%q): got %v", tt.password, got)
+		}
+	}
+	if CheckPassword([]byte("not a hash"), "Str0ng-pass") {
+		t.Error("a malformed hash matched")
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, tt := range []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"a.b_c-9", true},
+		{"abc", true},
+		{strings.Repeat("a", 32), true},
+		{"ab", false},
+		{strings.Repeat("a", 33), false},
+		{"", false},
+		{"alice smith", false},
+		{"alice@example.com", false},
+		{"alice\n", false},
+		{"élise", false},
+		{"../etc", false},
+	} {
+		err := ValidateUsername(tt.username)
+		if tt.valid && err != nil || !tt.valid && !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("ValidateUsername(%q): got %v", tt.username, err)
+		}
+	}
+}
+
+func TestValidatePasswordStrength(t *testing.T) {
+	for _, tt := range []struct {
+		username, password string
+		want               error
+	}{
+		{"alice", "Str0ng-pass", nil},
+		{"alice", "Abcdefg1", nil},
+		{"alice", "abcdefg1!", nil},
+		{"alice", "ABCDEFG1!", nil},
+		{"alice", "Abc-def!", nil},
+		{"alice", "Ab1!", ErrWeakPassword},
+		{"alice", "Abcdef1", ErrWeakPassword},
+		{"alice", "abcdefgh1", ErrWeakPassword},
+		{"alice", "abcdefghij", ErrWeakPassword},
+		{"alice", "12345678!", ErrWeakPassword},
+		{"alice", "Aa1!" + strings.Repeat("a", 68), nil},
+		{"alice", "Aa1!" + strings.Repeat("a", 69), ErrWeakPassword},
+		{"alice", "My-Alice-1", ErrPasswordHasName},
+		{"alice", "xALICEx-1", ErrPasswordHasName},
+		{"", "Str0ng-pass", nil},
+	} {
+		if err := ValidatePasswordStrength(tt.username, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("ValidatePasswordStrength(%q, %q): got %v, want %v", tt.username, tt.password, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// RefreshToken is the server-side record of an opaque refresh token.
+// Only the SHA-256 hash of the token is stored. All the tokens obtained
+// by rotating the token issued at sign in share its FamilyID.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	Username  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// RotatedAt is set once the token has been exchanged for a new pair
+	RotatedAt *time.Time
+	// RevokedAt is set on logout or when reuse of the family is detected
+	RevokedAt *time.Time
+}
+
+// RefreshStore persists refresh tokens.
+type RefreshStore interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	// Get returns ErrRefreshTokenNotFound if no token has the given hash.
+	Get(ctx context.Context, hash string) (*RefreshToken, error)
+	// MarkRotated flags the token as used. It reports false if the token
+	// was already rotated or revoked, so that concurrent uses of a single
+	// token cannot both succeed.
+	MarkRotated(ctx context.Context, hash string) (bool, error)
+	// RevokeFamily revokes every token of the family.
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+// NewOpaqueToken returns a random URL-safe token and the hash to store for it.
+func NewOpaqueToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of token.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SQLiteRefreshStore is a RefreshStore backed by a SQLite database.
+type SQLiteRefreshStore struct {
+	db *sql.DB
+}
+
+const createRefreshTokensTable = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  hash CHAR(64) PRIMARY KEY,
+  family_id CHAR(64) NOT NULL,
+  username VARCHAR(32) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  rotated_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family ON refresh_tokens (family_id);
+`
+
+// NewSQLiteRefreshStore creates the refresh_tokens table if needed and returns a store using db.
+func NewSQLiteRefreshStore(db *sql.DB) (*SQLiteRefreshStore, error) {
+	if _, err := db.Exec(createRefreshTokensTable); err != nil {
+		return nil, err
+	}
+	return &SQLiteRefreshStore{db: db}, nil
+}
+
+func (s *SQLiteRefreshStore) Create(ctx context.Context, t *RefreshToken) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (hash, family_id, username, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		t.Hash, t.FamilyID, t.Username, t.CreatedAt, t.ExpiresAt)
+	return err
+}
+
+func (s *SQLiteRefreshStore) Get(ctx context.Context, hash string) (*RefreshToken, error) {
+	var t RefreshToken
+	var rotatedAt, revokedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT hash, family_id, username, created_at, expires_at, rotated_at, revoked_at FROM refresh_tokens WHERE hash = ?", hash).
+		Scan(&t.Hash, &t.FamilyID, &t.Username, &t.CreatedAt, &t.ExpiresAt, &rotatedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rotatedAt.Valid {
+		t.RotatedAt = &rotatedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func (s *SQLiteRefreshStore) MarkRotated(ctx context.Context, hash string) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET rotated_at = ? WHERE hash = ? AND rotated_at IS NULL AND revoked_at IS NULL",
+		clock().UTC(), hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLiteRefreshStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		clock().UTC(), familyID)
+	return err
+}
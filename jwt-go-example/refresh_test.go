@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func refresh(t *testing.T, refreshToken string) (TokenPair, int) {
+	t.Helper()
+	rec := call(t, Refresh, map[string]string{"refresh_token": refreshToken}, "")
+	if rec.Code != http.StatusOK {
+		return TokenPair{}, rec.Code
+	}
+	return decode[TokenPair](t, rec), rec.Code
+}
+
+func TestRefreshRotation(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	call(t, Signup, creds, "")
+	first := decode[TokenPair](t, call(t, Signin, creds, ""))
+
+	// every refresh answers a new pair and retires the token presented
+	second, code := refresh(t, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("refresh: got %d %+v", code, second)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, second.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("new access token: got %d", rec.Code)
+	}
+	third, code := refresh(t, second.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh: got %d", code)
+	}
+
+	// presenting a rotated token revokes the whole family and the session
+	if _, code := refresh(t, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reuse of a rotated token: got %d", code)
+	}
+	if _, code := refresh(t, third.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("latest token of a revoked family: got %d", code)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, third.AccessToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token of a revoked family: got %d", rec.Code)
+	}
+
+	// another sign in starts a family of its own, which expires
+	other := decode[TokenPair](t, call(t, Signin, creds, ""))
+	if _, code := refresh(t, "not-a-token"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d", code)
+	}
+	now = now.Add(refreshTokenTTL + time.Second)
+	if _, code := refresh(t, other.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("expired token: got %d", code)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	call(t, Signup, creds, "")
+	pair := decode[TokenPair](t, call(t, Signin, creds, ""))
+
+	// of the requests racing with one token, exactly one gets a new pair
+	const n = 8
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- call(t, Refresh, map[string]string{"refresh_token": pair.RefreshToken}, "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	ok := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			ok++
+		} else if code != http.StatusUnauthorized {
+			t.Errorf("got %d", code)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d refreshes succeeded, want 1", ok)
+	}
+}
+
+func TestMarkRotatedIsAtomic(t *testing.T) {
+	// a file database, so that the updates run on several connections
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "refresh.db")+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSQLiteRefreshStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now().UTC()
+	_, hash, _ := NewOpaqueToken()
+	if err := store.Create(ctx, &RefreshToken{Hash: hash, FamilyID: "family", Username: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 16
+	results := make(chan bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rotated, err := store.MarkRotated(ctx, hash)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- rotated
+		}()
+	}
+	wg.Wait()
+	close(results)
+	won := 0
+	for rotated := range results {
+		if rotated {
+			won++
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d calls rotated the token, want 1", won)
+	}
+
+	// a revoked token cannot be rotated either
+	_, revoked, _ := NewOpaqueToken()
+	store.Create(ctx, &RefreshToken{Hash: revoked, FamilyID: "other", Username: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+	store.RevokeFamily(ctx, "other")
+	if rotated, err := store.MarkRotated(ctx, revoked); err != nil || rotated {
+		t.Errorf("rotate a revoked token: got %v, %v", rotated, err)
+	}
+	if token, err := store.Get(ctx, revoked); err != nil || token.RevokedAt == nil || token.RotatedAt != nil {
+		t.Errorf("revoked token: got %+v, %v", token, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// newTokenID returns a random value for the "jti" claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RevocationStore records the IDs of access tokens revoked before they expire.
+type RevocationStore interface {
+	// Revoke adds jti to the denylist until expiresAt, after which the token
+	// is rejected for having expired anyway.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// List returns the revoked IDs that have not expired, with their expiry.
+	List(ctx context.Context) (map[string]time.Time, error)
+}
+
+// SQLiteRevocationStore is a RevocationStore backed by a SQLite database.
+type SQLiteRevocationStore struct {
+	db *sql.DB
+}
+
+const createRevokedTokensTable = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti VARCHAR(32) PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+)
+`
+
+// NewSQLiteRevocationStore creates the revoked_tokens table if needed and returns a store using db.
+func NewSQLiteRevocationStore(db *sql.DB) (*SQLiteRevocationStore, error) {
+	if _, err := db.Exec(createRevokedTokensTable); err != nil {
+		return nil, err
+	}
+	return &SQLiteRevocationStore{db: db}, nil
+}
+
+func (s *SQLiteRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	// expired entries are useless, drop them while we are here
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", clock().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT(jti) DO NOTHING",
+		jti, expiresAt.UTC())
+	return err
+}
+
+func (s *SQLiteRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLiteRevocationStore) List(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens WHERE expires_at >= ?", clock().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		ids[jti] = expiresAt
+	}
+	return ids, rows.Err()
+}
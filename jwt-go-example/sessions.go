@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+var ErrSessionNotFound = problem.New(problem.ErrNotFound, "session not found")
+
+// lastSeenInterval limits how often the last seen time of a session is written
+const lastSeenInterval = time.Minute
+
+// Session is a sign in on one device. Its ID is the "jti" of the access token
+// issued at sign in, and every access token of the session carries it as the
+// "sid" claim. The refresh tokens of the session form the family of that ID.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"-"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// ExpiresAt is the expiry of the latest refresh token of the session
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"-"`
+	// Current is set when listing the sessions, for the one making the request
+	Current bool `json:"current"`
+}
+
+// SessionStore persists the sessions.
+type SessionStore interface {
+	CreateSession(ctx context.Context, s *Session) error
+	// GetSession returns ErrSessionNotFound if no session has the given ID.
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// ListSessions returns the sessions of the user that are neither revoked nor expired.
+	ListSessions(ctx context.Context, username string) ([]Session, error)
+	// Renew records a refresh of the session, from ip.
+	Renew(ctx context.Context, id, ip string, expiresAt time.Time) error
+	// Touch records that the session was used from ip, at most once per lastSeenInterval.
+	Touch(ctx context.Context, id, ip string) error
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// SQLiteSessionStore is a SessionStore backed by a SQLite database.
+type SQLiteSessionStore struct {
+	db *sql.DB
+}
+
+const createSessionsTable = `
+CREATE TABLE IF NOT EXISTS sessions (
+  id VARCHAR(32) PRIMARY KEY,
+  username VARCHAR(32) NOT NULL,
+  device VARCHAR(64) NOT NULL,
+  user_agent TEXT NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  last_seen_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS sessions_username ON sessions (username);
+`
+
+// NewSQLiteSessionStore creates the sessions table if needed and returns a store using db.
+func NewSQLiteSessionStore(db *sql.DB) (*SQLiteSessionStore, error) {
+	if _, err := db.Exec(createSessionsTable); err != nil {
+		return nil, err
+	}
+	return &SQLiteSessionStore{db: db}, nil
+}
+
+func (s *SQLiteSessionStore) CreateSession(ctx context.Context, sess *Session) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO sessions (id, username, device, user_agent, ip, created_at, last_seen_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID, sess.Username, sess.Device, sess.UserAgent, sess.IP,
+		sess.CreatedAt.UTC(), sess.LastSeenAt.UTC(), sess.ExpiresAt.UTC())
+	return err
+}
+
+const selectSession = `
+SELECT id, username, device, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at FROM sessions`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	var sess Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&sess.ID, &sess.Username, &sess.Device, &sess.UserAgent, &sess.IP,
+		&sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		sess.RevokedAt = &revokedAt.Time
+	}
+	return &sess, nil
+}
+
+func (s *SQLiteSessionStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	sess, err := scanSession(s.db.QueryRowContext(ctx, selectSession+" WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	return sess, err
+}
+
+func (s *SQLiteSessionStore) ListSessions(ctx context.Context, username string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		selectSession+" WHERE username = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_seen_at DESC",
+		username, clock().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *sess)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteSessionStore) Renew(ctx context.Context, id, ip string, expiresAt time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET ip = ?, last_seen_at = ?, expires_at = ? WHERE id = ?",
+		ip, clock().UTC(), expiresAt.UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteSessionStore) Touch(ctx context.Context, id, ip string) error {
+	now := clock().UTC()
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET ip = ?, last_seen_at = ? WHERE id = ? AND last_seen_at < ?",
+		ip, now, id, now.Add(-lastSeenInterval))
+	return err
+}
+
+func (s *SQLiteSessionStore) RevokeSession(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", clock().UTC(), id)
+	return err
+}
+
+// The sessions are stored in a SessionStore, which is set up in main
+var sessions SessionStore
+
+// deviceName describes the device of the request, from the X-Device-Name
+// header if the client sets it, or else from the user agent
+func deviceName(r *http.Request) string {
+	if name := strings.TrimSpace(r.Header.Get("X-Device-Name")); name != "" {
+		if len(name) > 64 {
+			name = name[:64]
+		}
+		return name
+	}
+	ua := r.UserAgent()
+	for _, device := range []string{"iPhone", "iPad", "Android", "Windows", "Macintosh", "Linux", "curl"} {
+		if strings.Contains(ua, device) {
+			return device
+		}
+	}
+	return "unknown"
+}
+
+// endSession revokes the session, its refresh tokens and its access tokens.
+// Refresh token families created before sessions existed have no session,
+// only their refresh tokens are revoked.
+func endSession(ctx context.Context, id string) error {
+	if err := refreshTokens.RevokeFamily(ctx, id); err != nil {
+		return err
+	}
+	sess, err := sessions.GetSession(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := sessions.RevokeSession(ctx, id); err != nil {
+		return err
+	}
+	// Denying the session ID rejects every access token carrying it as "sid"
+	return revocations.Revoke(ctx, id, sess.ExpiresAt)
+}
+
+// ListSessions returns the active sessions of the signed in user
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	list, err := sessions.ListSessions(r.Context(), claims.Username)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	for i := range list {
+		list[i].Current = list[i].ID == claims.SessionID
+	}
+	writeJSON(w, http.StatusOK, map[string][]Session{"sessions": list})
+}
+
+// DeleteSession revokes one of the sessions of the signed in user
+func DeleteSession(w http.ResponseWriter, r *http.Request) {
+	claims, _ := ClaimsFromContext(r.Context())
+	sess, err := sessions.GetSession(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrSessionNotFound) || (err == nil && sess.Username != claims.Username) {
+		writeError(w, r, http.StatusNotFound, "not_found", "no such session")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	if err := endSession(r.Context(), sess.ID); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	audit(r, EventLogout, claims.Username, "session "+sess.ID+" revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CachedRevocations keeps the denylist of a RevocationStore in memory, so that
+// checking a token does not query the database. Revocations made through it
+// are seen at once; those made by other instances after Reload is called.
+type CachedRevocations struct {
+	store RevocationStore
+	mu    sync.RWMutex
+	ids   map[string]time.Time
+}
+
+// NewCachedRevocations loads the denylist of store.
+func NewCachedRevocations(ctx context.Context, store RevocationStore) (*CachedRevocations, error) {
+	c := &CachedRevocations{store: store}
+	if err := c.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload replaces the cached denylist with the one of the store.
+func (c *CachedRevocations) Reload(ctx context.Context) error {
+	ids, err := c.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.ids = ids
+	c.mu.Unlock()
+	return nil
+}
+
+// ReloadEvery calls Reload at every interval, for the revocations made by other instances.
+func (c *CachedRevocations) ReloadEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.Reload(context.Background()); err != nil {
+			log.Println("Reloading the token denylist failed:", err)
+		}
+	}
+}
+
+func (c *CachedRevocations) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := c.store.Revoke(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.ids[jti] = expiresAt
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *CachedRevocations) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.ids[jti]
+	return ok, nil
+}
+
+func (c *CachedRevocations) List(ctx context.Context) (map[string]time.Time, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids := make(map[string]time.Time, len(c.ids))
+	for id, exp := range c.ids {
+		ids[id] = exp
+	}
+	return ids, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRevokeSession(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+	laptop := decode[TokenPair](t, call(t, Signin, creds, ""))
+	phone := decode[TokenPair](t, call(t, Signin, creds, ""))
+
+	list := decode[map[string][]Session](t, call(t, RequireAuth(ListSessions), nil, laptop.AccessToken))["sessions"]
+	if len(list) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(list))
+	}
+	var phoneID string
+	for _, s := range list {
+		if !s.Current {
+			phoneID = s.ID
+		}
+	}
+	if phoneID == "" {
+		t.Fatal("no session is marked as current")
+	}
+
+	// someone else cannot see or revoke alice's sessions
+	bob := Credentials{Username: "bob", Password: "Str0ng-pass"}
+	call(t, Signup, bob, "")
+	bobPair := decode[TokenPair](t, call(t, Signin, bob, ""))
+	del := func(accessToken, id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/sessions/"+id, nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		RequireAuth(DeleteSession)(rec, req)
+		return rec.Code
+	}
+	if code := del(bobPair.AccessToken, phoneID); code != http.StatusNotFound {
+		t.Errorf("bob deleting alice's session: got %d", code)
+	}
+
+	if code := del(laptop.AccessToken, phoneID); code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", code)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, phone.AccessToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session: got %d", rec.Code)
+	}
+	body, _ := json.Marshal(map[string]string{"refresh_token": phone.RefreshToken})
+	rec := httptest.NewRecorder()
+	Refresh(rec, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token of the revoked session: got %d", rec.Code)
+	}
+	if rec := call(t, RequireAuth(Welcome), nil, laptop.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("access token of the other session: got %d", rec.Code)
+	}
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+	laptop := decode[TokenPair](t, call(t, Signin, creds, ""))
+	stolen := decode[TokenPair](t, call(t, Signin, creds, ""))
+
+	change := PasswordChange{Password: "Str0ng-pass", NewPassword: "N3w-pass!!"}
+	if rec := call(t, RequireAuth(ChangePassword), change, laptop.AccessToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("change password: got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := call(t, RequireAuth(Welcome), nil, stolen.AccessToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token of the other session: got %d", rec.Code)
+	}
+	body, _ := json.Marshal(map[string]string{"refresh_token": stolen.RefreshToken})
+	rec := httptest.NewRecorder()
+	Refresh(rec, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token of the other session: got %d", rec.Code)
+	}
+
+	if rec := call(t, RequireAuth(Welcome), nil, laptop.AccessToken); rec.Code != http.StatusOK {
+		t.Errorf("access token of the current session: got %d", rec.Code)
+	}
+	body, _ = json.Marshal(map[string]string{"refresh_token": laptop.RefreshToken})
+	rec = httptest.NewRecorder()
+	Refresh(rec, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("refresh token of the current session: got %d", rec.Code)
+	}
+	list := decode[map[string][]Session](t, call(t, RequireAuth(ListSessions), nil, laptop.AccessToken))["sessions"]
+	if len(list) != 1 || !list[0].Current {
+		t.Errorf("sessions after the change: got %+v", list)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+	// import the sqlite driver, registered as "sqlite3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrUserNotFound = problem.New(problem.ErrNotFound, "user not found")
+	ErrUserExists   = problem.New(problem.ErrConflict, "user already exists")
+)
+
+// User is a registered account. Only the password hash is ever stored.
+type User struct {
+	Username     string
+	PasswordHash []byte
+	// Roles and Scopes are copied into the access tokens issued to the user
+	Roles     []string
+	Scopes    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// UserStore persists users and their password hashes.
+type UserStore interface {
+	// GetUser returns ErrUserNotFound if no user has the given name.
+	GetUser(ctx context.Context, username string) (*User, error)
+	// CreateUser returns ErrUserExists if the name is already taken.
+	CreateUser(ctx context.Context, username string, passwordHash []byte) error
+	// UpdatePassword returns ErrUserNotFound if no user has the given name.
+	UpdatePassword(ctx context.Context, username string, passwordHash []byte) error
+	// SetPermissions replaces the roles and scopes of the user.
+	// It returns ErrUserNotFound if no user has the given name.
+	SetPermissions(ctx context.Context, username string, roles, scopes []string) error
+}
+
+// SQLiteUserStore is a UserStore backed by a SQLite database.
+type SQLiteUserStore struct {
+	db *sql.DB
+}
+
+const createUsersTable = `
+CREATE TABLE IF NOT EXISTS users (
+  username VARCHAR(32) PRIMARY KEY,
+  password_hash BLOB NOT NULL,
+  roles TEXT NOT NULL DEFAULT '',
+  scopes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+)
+`
+
+// NewSQLiteUserStore creates the users table if needed and returns a store using db.
+func NewSQLiteUserStore(db *sql.DB) (*SQLiteUserStore, error) {
+	if _, err := db.Exec(createUsersTable); err != nil {
+		return nil, err
+	}
+	// Databases created before roles and scopes were added lack these columns
+	for _, column := range []string{"roles", "scopes"} {
+		if err := addColumnIfMissing(db, "users", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return nil, err
+		}
+	}
+	return &SQLiteUserStore{db: db}, nil
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+func (s *SQLiteUserStore) GetUser(ctx context.Context, username string) (*User, error) {
+	var u User
+	var roles, scopes string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT username, password_hash, roles, scopes, created_at, updated_at FROM users WHERE username = ?", username).
+		Scan(&u.Username, &u.PasswordHash, &roles, &scopes, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Roles and scopes are stored as space separated lists
+	u.Roles = strings.Fields(roles)
+	u.Scopes = strings.Fields(scopes)
+	return &u, nil
+}
+
+func (s *SQLiteUserStore) CreateUser(ctx context.Context, username string, passwordHash []byte) error {
+	now := clock().UTC()
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO users (username, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?) ON CONFLICT(username) DO NOTHING",
+		username, passwordHash, now, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserExists
+	}
+	return nil
+}
+
+func (s *SQLiteUserStore) UpdatePassword(ctx context.Context, username string, passwordHash []byte) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET password_hash = ?, updated_at = ? WHERE username = ?",
+		passwordHash, clock().UTC(), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserStore) SetPermissions(ctx context.Context, username string, roles, scopes []string) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE users SET roles = ?, scopes = ?, updated_at = ? WHERE username = ?",
+		strings.Join(roles, " "), strings.Join(scopes, " "), clock().UTC(), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func openTestUserStore(t *testing.T) *SQLiteUserStore {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSQLiteUserStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSQLiteUserStore(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	oldClock := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = oldClock })
+	store := openTestUserStore(t)
+	ctx := context.Background()
+
+	if err := store.CreateUser(ctx, "alice", []byte("hash-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(ctx, "alice", []byte("hash-2")); !errors.Is(err, ErrUserExists) {
+		t.Errorf("duplicate username: got %v", err)
+	}
+	user, err := store.GetUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the duplicate did not overwrite the first hash
+	if user.Username != "alice" || !bytes.Equal(user.PasswordHash, []byte("hash-1")) || !user.CreatedAt.Equal(now) || len(user.Roles) != 0 {
+		t.Errorf("lookup: got %+v", user)
+	}
+	if _, err := store.GetUser(ctx, "bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("lookup of a missing user: got %v", err)
+	}
+	// usernames are compared exactly
+	if _, err := store.GetUser(ctx, "Alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("lookup with another case: got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := store.UpdatePassword(ctx, "alice", []byte("hash-3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetPermissions(ctx, "alice", []string{"admin"}, []string{"notes:read", "notes:write"}); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = store.GetUser(ctx, "alice")
+	if !bytes.Equal(user.PasswordHash, []byte("hash-3")) || !user.UpdatedAt.Equal(now) ||
+		fmt.Sprint(user.Roles, user.Scopes) != "[admin] [notes:read notes:write]" {
+		t.Errorf("after the updates: got %+v", user)
+	}
+	if err := store.UpdatePassword(ctx, "bob", []byte("hash")); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("update the password of a missing user: got %v", err)
+	}
+	if err := store.SetPermissions(ctx, "bob", nil, nil); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("set the permissions of a missing user: got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ThrottleConfig sets how a LoginThrottle slows down repeated failures.
+type ThrottleConfig struct {
+	// MaxFailures consecutive failures lock the key for LockoutDuration
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// After n failures, the next attempt must wait BaseDelay * 2^(n-1), up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginThrottle tracks failed sign in attempts per key, such as a username
+// or an IP address, and tells when the next attempt is allowed.
+type LoginThrottle struct {
+	cfg     ThrottleConfig
+	mu      sync.Mutex
+	entries map[string]*loginAttempts
+}
+
+// NewLoginThrottle returns a throttle that forgets idle keys every minute.
+func NewLoginThrottle(cfg ThrottleConfig) *LoginThrottle {
+	t := &LoginThrottle{cfg: cfg, entries: make(map[string]*loginAttempts)}
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			t.forgetIdle()
+		}
+	}()
+	return t
+}
+
+func (t *LoginThrottle) forgetIdle() {
+	now := clock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, a := range t.entries {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > t.cfg.Window {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Wait returns how long the key must wait before its next attempt, zero if
+// it may try now, and whether the key is locked out.
+func (t *LoginThrottle) Wait(key string) (time.Duration, bool) {
+	now := clock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now), true
+	}
+	if now.Sub(a.lastFailure) > t.cfg.Window {
+		delete(t.entries, key)
+		return 0, false
+	}
+	if next := a.lastFailure.Add(t.delay(a.failures)); now.Before(next) {
+		return next.Sub(now), false
+	}
+	return 0, false
+}
+
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	d := t.cfg.BaseDelay
+	for i := 1; i < failures && d < t.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, t.cfg.MaxDelay)
+}
+
+// Fail records a failed attempt and reports whether it locked the key out.
+func (t *LoginThrottle) Fail(key string) bool {
+	now := clock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.entries[key]
+	if !ok || (now.After(a.lockedUntil) && now.Sub(a.lastFailure) > t.cfg.Window) {
+		a = &loginAttempts{}
+		t.entries[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= t.cfg.MaxFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(t.cfg.LockoutDuration)
+		return true
+	}
+	return false
+}
+
+// Reset forgets the failures of key and lifts its lockout.
+func (t *LoginThrottle) Reset(key string) {
+	t.mu.Lock()
+	delete(t.entries, key)
+	t.mu.Unlock()
+}
+
+// ResetPrefix forgets the failures of every key starting with prefix and
+// lifts their lockouts.
+func (t *LoginThrottle) ResetPrefix(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// dummyHash is compared against the passwords sent for unknown users, so that
+// the response time does not tell whether a username exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// The throttles of the sign in attempts per username and client IP, see
+// userKey, and per client IP. The failures of a username are counted per
+// client, so that anyone who knows the username cannot lock its owner out.
+// The IP throttle locks out clients making many attempts across usernames
+var (
+	userThrottle = NewLoginThrottle(ThrottleConfig{
+		MaxFailures:     5,
+		LockoutDuration: 15 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+		Window:          15 * time.Minute,
+	})
+	ipThrottle = NewLoginThrottle(ThrottleConfig{
+		MaxFailures:     50,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	})
+)
+
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// userKey is the key of userThrottle for the attempts of the request's client
+// at username
+func userKey(r *http.Request, username string) string {
+	return username + "\x00" + clientIP(r)
+}
+
+// userKeys is the prefix of the keys of userThrottle for username, from any client
+func userKeys(username string) string {
+	return username + "\x00"
+}
+
+// throttled answers 429 Too Many Requests if the username, from this client,
+// or the client IP must wait before trying again. Unknown usernames are throttled too, so
+// that the response does not tell whether a user exists.
+func throttled(w http.ResponseWriter, r *http.Request, username string) bool {
+	userWait, _ := userThrottle.Wait(userKey(r, username))
+	ipWait, _ := ipThrottle.Wait(clientIP(r))
+	wait := max(userWait, ipWait)
+	if wait <= 0 {
+		return false
+	}
+	audit(r, EventSigninFailure, username, "throttled")
+	w.Header().Set("Retry-After", fmt.Sprint(int((wait+time.Second-1)/time.Second)))
+	writeError(w, r, http.StatusTooManyRequests, "too_many_attempts", "too many failed attempts, try again later")
+	return true
+}
+
+// recordFailure counts a failed attempt against the username and the client IP
+func recordFailure(r *http.Request, username string) {
+	if userThrottle.Fail(userKey(r, username)) {
+		log.Printf("Locked out user %q from %s after repeated failures", username, clientIP(r))
+		audit(r, EventLockout, username, "repeated failures from client "+clientIP(r))
+	}
+	if ipThrottle.Fail(clientIP(r)) {
+		log.Printf("Locked out client %s after repeated failures", clientIP(r))
+		audit(r, EventLockout, "", "client "+clientIP(r)+" after repeated failures")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSigninLockout(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+
+	// unknown and known usernames get the same answers
+	for _, username := range []string{"alice", "mallory"} {
+		wrong := Credentials{Username: username, Password: "wrong"}
+		for i := 1; i <= userThrottle.cfg.MaxFailures; i++ {
+			if rec := call(t, Signin, wrong, ""); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%s: failure %d: got %d", username, i, rec.Code)
+			}
+			// the next attempt is refused until the backoff has elapsed
+			if i < userThrottle.cfg.MaxFailures {
+				if rec := call(t, Signin, wrong, ""); rec.Code != http.StatusTooManyRequests {
+					t.Fatalf("%s: attempt during backoff %d: got %d", username, i, rec.Code)
+				}
+				now = now.Add(userThrottle.delay(i))
+			}
+		}
+	}
+
+	// the correct password is refused during the lockout
+	rec := call(t, Signin, creds, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("signin while locked out: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	now = now.Add(userThrottle.cfg.LockoutDuration)
+	if rec := call(t, Signin, creds, ""); rec.Code != http.StatusOK {
+		t.Fatalf("signin after the lockout: got %d", rec.Code)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	// alice is locked out from two clients
+	keys := []string{"alice\x00192.0.2.1", "alice\x00198.51.100.7"}
+	for _, key := range keys {
+		for i := 0; i < userThrottle.cfg.MaxFailures; i++ {
+			userThrottle.Fail(key)
+		}
+		if _, locked := userThrottle.Wait(key); !locked {
+			t.Fatalf("%q is not locked out", key)
+		}
+	}
+	userThrottle.Fail("alice2\x00192.0.2.1")
+	req := httptest.NewRequest(http.MethodDelete, "/admin/users/alice/lockout", nil)
+	req.SetPathValue("username", "alice")
+	rec := httptest.NewRecorder()
+	Unlock(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unlock: got %d", rec.Code)
+	}
+	for _, key := range keys {
+		if wait, locked := userThrottle.Wait(key); locked || wait != 0 {
+			t.Fatalf("%q after unlock: wait %v, locked %v", key, wait, locked)
+		}
+	}
+	if wait, _ := userThrottle.Wait("alice2\x00192.0.2.1"); wait == 0 {
+		t.Error("unlocking alice reset the failures of alice2")
+	}
+}
+
+// signinFrom signs in with creds from the client IP
+func signinFrom(creds Credentials, ip string) int {
+	b, _ := json.Marshal(creds)
+	req := httptest.NewRequest(http.MethodPost, "/signin", bytes.NewReader(b))
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	Signin(rec, req)
+	return rec.Code
+}
+
+func TestLockoutIsPerClient(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	setupTestStores(t, &now)
+
+	creds := Credentials{Username: "alice", Password: "Str0ng-pass"}
+	if rec := call(t, Signup, creds, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: got %d", rec.Code)
+	}
+
+	// mallory locks alice out from her own address, without any credentials
+	wrong := Credentials{Username: "alice", Password: "wrong"}
+	for i := 1; i <= userThrottle.cfg.MaxFailures; i++ {
+		if code := signinFrom(wrong, "203.0.113.9"); code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: got %d", i, code)
+		}
+		now = now.Add(userThrottle.delay(i))
+	}
+	if code := signinFrom(creds, "203.0.113.9"); code != http.StatusTooManyRequests {
+		t.Fatalf("signin from the locked out client: got %d", code)
+	}
+
+	// alice still signs in from her own client
+	if code := signinFrom(creds, "192.0.2.1"); code != http.StatusOK {
+		t.Fatalf("signin from another client: got %d", code)
+	}
+}
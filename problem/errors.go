@@ -0,0 +1,84 @@
+// Package problem maps the errors of a service to HTTP status codes and
+// renders them as RFC 7807 problem details, in application/problem+json.
+package problem
+
+import (
+	"errors"
+	"strings"
+)
+
+// The kinds of domain errors. A service declares its own errors with New
+// or Wrap, and Write answers them with the status code of their kind.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrValidation      = errors.New("validation failed")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrPreconditionFailed is a conditional request, with If-Match say,
+	// whose condition is false, and ErrPreconditionRequired one that
+	// should have had a condition
+	ErrPreconditionFailed   = errors.New("precondition failed")
+	ErrPreconditionRequired = errors.New("precondition required")
+)
+
+// kindError is an error with a message of its own, of one of the kinds
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// New returns an error of the given kind with the given message:
+//
+//	var ErrUserNotFound = problem.New(problem.ErrNotFound, "user not found")
+func New(kind error, message string) error {
+	return &kindError{kind: kind, err: errors.New(message)}
+}
+
+// Wrap classifies err as an error of the given kind, keeping its message.
+// errors.Is and errors.As still see err.
+func Wrap(kind error, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// FieldError is a problem with the value of one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the problems of a request, field by field. It is an
+// error of the ErrValidation kind, and its fields are listed in the
+// "errors" member of the problem.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		messages[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+// Add records a problem with a field
+func (e *ValidationError) Add(field, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+// Err returns e if it has recorded a problem, and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,3 @@
+module github.com/favtuts/problem
+
+go 1.21
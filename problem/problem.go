@@ -0,0 +1,138 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ContentType is the media type of the problem details
+const ContentType = "application/problem+json"
+
+// TypeBase is prefixed to the name of the kind of a problem to make its
+// type URI. Set it to the URL where the problem types are documented.
+var TypeBase = "https://example.com/problems/"
+
+// Problem holds the members of a RFC 7807 problem details object, along
+// with the extension members used by the services of this repository.
+type Problem struct {
+	// Type is a URI naming the kind of problem, Title is its short summary
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the problem, for the client
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request
+	Instance string `json:"instance,omitempty"`
+	// TraceID is logged along with the error, to find it from an answer
+	TraceID string `json:"trace_id,omitempty"`
+	// Code is a machine readable error code, more precise than the type
+	Code string `json:"code,omitempty"`
+	// Errors lists the invalid fields of a request
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// kind is a kind of domain error, with the status and type it is answered with
+type kind struct {
+	err    error
+	status int
+	name   string
+}
+
+// The kinds, in the order they are checked. An error wrapping several kinds
+// gets the first one, so that Wrap(ErrBadRequest, &ValidationError{...})
+// is a 400 rather than a 422.
+var kinds = []kind{
+	{ErrBadRequest, http.StatusBadRequest, "bad-request"},
+	{ErrValidation, http.StatusUnprocessableEntity, "validation"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not-found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrTooManyRequests, http.StatusTooManyRequests, "too-many-requests"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition-failed"},
+	{ErrPreconditionRequired, http.StatusPreconditionRequired, "precondition-required"},
+}
+
+// typeFor returns the type URI of the problems of a status code
+func typeFor(status int) string {
+	for _, k := range kinds {
+		if k.status == status {
+			return TypeBase + k.name
+		}
+	}
+	if status >= 500 {
+		return TypeBase + "internal"
+	}
+	// RFC 7807 names the problems that are just their status code about:blank
+	return "about:blank"
+}
+
+// Status returns the status code of the kind of err, 500 if it has none
+func Status(err error) int {
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// FromError returns the problem of err. The message of an error of no kind
+// could reveal the internals of the service, a SQL error say, so it is left
+// out of the problem.
+func FromError(err error) *Problem {
+	p := &Problem{Status: Status(err)}
+	if p.Status == http.StatusInternalServerError {
+		p.Detail = "an unexpected error occurred, quote the trace id to report it"
+		return p
+	}
+	p.Detail = err.Error()
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		p.Errors = invalid.Errors
+		p.Detail = "the request has invalid fields"
+	}
+	return p
+}
+
+// Write answers err as a problem. The errors of no kind are logged with the
+// trace id of the request, since the answer does not tell what they were.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	p := FromError(err)
+	p.TraceID = TraceID(r)
+	if p.Status == http.StatusInternalServerError {
+		log.Printf("trace %s: %s %s: %v", p.TraceID, r.Method, r.URL.Path, err)
+	}
+	Render(w, r, p)
+}
+
+// Error answers a problem of the given status, code and detail, for the
+// errors that are found by the handlers rather than returned to them.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Render(w, r, &Problem{Status: status, Code: code, Detail: detail})
+}
+
+// Render fills in the members of p left empty and writes it
+func Render(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Type == "" {
+		p.Type = typeFor(p.Status)
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.TraceID == "" {
+		p.TraceID = TraceID(r)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,97 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errNoteNotFound = New(ErrNotFound, "note not found")
+
+func TestStatus(t *testing.T) {
+	var invalid ValidationError
+	invalid.Add("title", "is required")
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errNoteNotFound, http.StatusNotFound},
+		{fmt.Errorf("loading: %w", errNoteNotFound), http.StatusNotFound},
+		{New(ErrConflict, "user already exists"), http.StatusConflict},
+		{&invalid, http.StatusUnprocessableEntity},
+		{Wrap(ErrBadRequest, &invalid), http.StatusBadRequest},
+		{New(ErrUnauthorized, "invalid credentials"), http.StatusUnauthorized},
+		{New(ErrPreconditionFailed, "the note has changed"), http.StatusPreconditionFailed},
+		{errors.New("sql: database is closed"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := Status(tt.err); got != tt.want {
+			t.Errorf("Status(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+	if !errors.Is(errNoteNotFound, errNoteNotFound) || errNoteNotFound.Error() != "note not found" {
+		t.Error("New lost the identity or the message of the error")
+	}
+}
+
+func serve(h http.Handler, header http.Header) (*httptest.ResponseRecorder, Problem) {
+	r := httptest.NewRequest("GET", "/notes/42", nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var p Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	return w, p
+}
+
+func TestWrite(t *testing.T) {
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, fmt.Errorf("fetching: %w", errNoteNotFound))
+	}))
+	w, p := serve(h, nil)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := Problem{
+		Type:     TypeBase + "not-found",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "fetching: note not found",
+		Instance: "/notes/42",
+		TraceID:  w.Header().Get(TraceHeader),
+	}
+	if fmt.Sprint(p) != fmt.Sprint(want) || p.TraceID == "" {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+
+	// the incoming trace id is kept
+	_, p = serve(h, http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}})
+	if p.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("traceparent: got trace id %q", p.TraceID)
+	}
+	_, p = serve(h, http.Header{"X-Request-Id": {"bad\nid"}})
+	if p.TraceID == "bad\nid" {
+		t.Error("an id with a newline was kept")
+	}
+}
+
+func TestWriteHidesInternalErrors(t *testing.T) {
+	var invalid ValidationError
+	invalid.Add("title", "is required")
+	for _, err := range []error{errors.New("no such table: notes"), &invalid} {
+		_, p := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Write(w, r, err)
+		}), nil)
+		switch {
+		case p.Status == http.StatusInternalServerError && p.Detail == err.Error():
+			t.Errorf("the internal error was sent: %+v", p)
+		case p.Status == http.StatusUnprocessableEntity && (len(p.Errors) != 1 || p.Errors[0].Field != "title"):
+			t.Errorf("the field errors are missing: %+v", p)
+		}
+	}
+}
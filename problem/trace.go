@@ -0,0 +1,67 @@
+package problem
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceHeader is the header of the responses carrying the trace id
+const TraceHeader = "X-Request-Id"
+
+type traceKey struct{}
+
+// Middleware gives every request a trace id, sent back in the X-Request-Id
+// header and found by TraceID. Without it, the problems get a new trace id
+// each.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := incomingTraceID(r)
+		w.Header().Set(TraceHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceKey{}, id)))
+	})
+}
+
+// TraceID returns the trace id of the request, given by Middleware
+func TraceID(r *http.Request) string {
+	if id, ok := r.Context().Value(traceKey{}).(string); ok {
+		return id
+	}
+	return incomingTraceID(r)
+}
+
+// incomingTraceID reuses the trace id of a W3C traceparent header or an
+// X-Request-Id header, so that the logs of the services a request went
+// through can be matched, or else returns a new one
+func incomingTraceID(r *http.Request) string {
+	// traceparent: version-traceid-parentid-flags
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && isTraceID(parts[1]) {
+		return parts[1]
+	}
+	if id := r.Header.Get(TraceHeader); id != "" && len(id) <= 64 && isPrintable(id) {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isTraceID(s string) bool {
+	if len(s) != 32 || s == strings.Repeat("0", 32) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// isPrintable keeps the ids that cannot forge log lines or headers
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 
@@ -24,6 +25,9 @@ func main() {
 		router.GET("/notes", getAllNotes)
 		router.POST("/notes", createNewNote)
 		router.GET("/notes/:note_id", getSingleNote)
+		router.PUT("/notes/:note_id", updateNote)
+		router.PATCH("/notes/:note_id", patchNote)
+		router.DELETE("/notes/:note_id", deleteNote)
 		router.Run(":8000")
 	}
 }
@@ -33,6 +37,12 @@ type NoteParams struct {
 	Body  string `json:"body"`
 }
 
+// NotePatch holds the fields of a partial update, the missing ones are left unchanged
+type NotePatch struct {
+	Title *string `json:"title"`
+	Body  *string `json:"body"`
+}
+
 func createNewNote(c *gin.Context) {
 	var params NoteParams
 	var note Note
@@ -72,6 +82,60 @@ func getSingleNote(c *gin.Context) {
 			"message": "Single Note",
 			"note":    note,
 		})
+	} else {
+		writeNoteError(c, err)
+	}
+}
+func updateNote(c *gin.Context) {
+	var params NoteParams
+	var note Note
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	_, err := note.update(c.Param("note_id"), params)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note updated successfully",
+			"note":    note,
+		})
+	} else {
+		writeNoteError(c, err)
+	}
+}
+func patchNote(c *gin.Context) {
+	var params NotePatch
+	var note Note
+	if err := c.ShouldBindJSON(&params); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	_, err := note.patch(c.Param("note_id"), params)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note updated successfully",
+			"note":    note,
+		})
+	} else {
+		writeNoteError(c, err)
+	}
+}
+func deleteNote(c *gin.Context) {
+	var note Note
+	err := note.delete(c.Param("note_id"))
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note deleted successfully",
+		})
+	} else {
+		writeNoteError(c, err)
+	}
+}
+
+// writeNoteError answers 404 for a missing note and 500 for any other error
+func writeNoteError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNoteNotFound) {
+		c.String(http.StatusNotFound, err.Error())
 	} else {
 		c.String(http.StatusInternalServerError, err.Error())
 	}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"time"
 )
 
+var ErrNoteNotFound = errors.New("note not found")
+
 type Note struct {
 	Id        int       `json:"id"`
 	Title     string    `json:"title"`
@@ -52,5 +56,48 @@ func (note *Note) Fetch(id string) (*Note, error) {
 	err := DB.QueryRow(
 		"SELECT id, title, body, created_at, updated_at FROM notes WHERE id=?", id).Scan(
 		&note.Id, &note.Title, &note.Body, &note.CreatedAt, &note.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return note, ErrNoteNotFound
+	}
 	return note, err
 }
+func (note *Note) update(id string, data NoteParams) (*Note, error) {
+	var updated_at = time.Now().UTC()
+	result, err := DB.Exec("UPDATE notes SET title=?, body=?, updated_at=? WHERE id=?",
+		data.Title, data.Body, updated_at, id)
+	if err != nil {
+		log.Println("Unable to update note", err.Error())
+		return note, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return note, ErrNoteNotFound
+	}
+	return note.Fetch(id)
+}
+
+// patch changes only the fields that are set, in a single statement so that
+// it cannot overwrite a concurrent change of the other fields
+func (note *Note) patch(id string, data NotePatch) (*Note, error) {
+	var updated_at = time.Now().UTC()
+	result, err := DB.Exec("UPDATE notes SET title=COALESCE(?, title), body=COALESCE(?, body), updated_at=? WHERE id=?",
+		data.Title, data.Body, updated_at, id)
+	if err != nil {
+		log.Println("Unable to patch note", err.Error())
+		return note, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return note, ErrNoteNotFound
+	}
+	return note.Fetch(id)
+}
+func (note *Note) delete(id string) error {
+	result, err := DB.Exec("DELETE FROM notes WHERE id=?", id)
+	if err != nil {
+		log.Println("Unable to delete note", err.Error())
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNoteNotFound
+	}
+	return nil
+}
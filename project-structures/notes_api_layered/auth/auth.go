@@ -0,0 +1,84 @@
+// Package auth verifies the bearer tokens issued by jwt-go-example, whose
+// public keys it fetches from the JWKS endpoint of that server.
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/username/notes_api_layered/models"
+)
+
+// Claims are the claims of the tokens of jwt-go-example. The Subject is the
+// username, or the client ID of the tokens issued to OAuth clients, which
+// have a ClientID.
+type Claims struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	// Scope is a space separated list, as in OAuth 2.0
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasScope reports whether the space separated "scope" claim contains scope
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// The algorithms of the keys of jwt-go-example. The HMAC ones are refused,
+// since the notes API only knows public keys.
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var (
+	ErrInvalidSubject = errors.New("the token subject must be a single line of at most 64 characters")
+	// ErrClientToken is returned for the tokens issued to OAuth clients,
+	// whose client ID could otherwise own the notes of a user of that name
+	ErrClientToken = errors.New("the tokens of OAuth clients are refused, a user token is required")
+)
+
+// Verifier checks the tokens signed by the keys of Keyfunc for the issuer
+// and the audience
+type Verifier struct {
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway given to the expiry and the other times
+	ClockSkew time.Duration
+	Keyfunc   jwt.Keyfunc
+	// Introspector, if set, checks that the tokens were not revoked.
+	// Without it, a revoked token is accepted until it expires.
+	Introspector *Introspector
+}
+
+// Verify parses a token and returns its claims if it is valid: signed by
+// one of the keys, not expired and issued by the issuer for the audience.
+// Its subject becomes the owner of notes, so it must fit in their column,
+// and it must be a user rather than an OAuth client.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.Keyfunc,
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+		jwt.WithLeeway(v.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID != "" {
+		return nil, ErrClientToken
+	}
+	sub := claims.Subject
+	if sub == "" || utf8.RuneCountInString(sub) > models.MaxUserLength || strings.IndexFunc(sub, unicode.IsControl) >= 0 {
+		return nil, ErrInvalidSubject
+	}
+	return claims, nil
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sign returns a token for the subject signed by key, valid for an hour
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key crypto.Signer, subject, audience string) string {
+	t.Helper()
+	now := time.Now()
+	token := jwt.NewWithClaims(method, &Claims{
+		Username: subject,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "jwt-go-example",
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{audience},
+		},
+	})
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestVerify(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	v := &Verifier{
+		Issuer:   "jwt-go-example",
+		Audience: "jwt-go-example",
+		Keyfunc:  func(*jwt.Token) (any, error) { return private.Public(), nil },
+	}
+	claims, err := v.Verify(sign(t, jwt.SigningMethodEdDSA, "k1", private, "alice", "jwt-go-example"))
+	if err != nil || claims.Subject != "alice" || claims.Username != "alice" {
+		t.Fatalf("verify: got %+v, %v", claims, err)
+	}
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer: "jwt-go-example", Subject: "alice", Audience: jwt.ClaimStrings{"jwt-go-example"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("secret"))
+	client, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &Claims{
+		ClientID: "alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "jwt-go-example", Subject: "alice", Audience: jwt.ClaimStrings{"jwt-go-example"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString(private)
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"mfa audience": {sign(t, jwt.SigningMethodEdDSA, "k1", private, "alice", "jwt-go-example/mfa"), jwt.ErrTokenInvalidAudience},
+		"no subject":   {sign(t, jwt.SigningMethodEdDSA, "k1", private, "", "jwt-go-example"), ErrInvalidSubject},
+		"long subject": {sign(t, jwt.SigningMethodEdDSA, "k1", private, strings.Repeat("a", 65), "jwt-go-example"), ErrInvalidSubject},
+		"hmac":         {hmac, jwt.ErrTokenSignatureInvalid},
+		"client":       {client, ErrClientToken},
+		"garbage":      {"not.a.token", jwt.ErrTokenMalformed},
+	} {
+		if _, err := v.Verify(tc.token); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := []jwk{
+		{Kty: "RSA", Kid: "rsa", Alg: "RS256", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "EC", Kid: "ec", Alg: "ES256", Crv: "P-256", X: b64(ecKey.X.FillBytes(make([]byte, 32))), Y: b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{Kty: "OKP", Kid: "ed", Alg: "EdDSA", Crv: "Ed25519", X: b64(edKey.Public().(ed25519.PublicKey))},
+		{Kty: "oct", Kid: "secret"},
+	}
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": keys})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	v := &Verifier{Issuer: "jwt-go-example", Audience: "jwt-go-example", Keyfunc: jwks.Keyfunc}
+	for _, tc := range []struct {
+		method jwt.SigningMethod
+		kid    string
+		key    crypto.Signer
+	}{
+		{jwt.SigningMethodRS256, "rsa", rsaKey},
+		{jwt.SigningMethodES256, "ec", ecKey},
+		{jwt.SigningMethodEdDSA, "ed", edKey},
+	} {
+		if _, err := v.Verify(sign(t, tc.method, tc.kid, tc.key, "alice", "jwt-go-example")); err != nil {
+			t.Errorf("%s: %v", tc.kid, err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched the keys %d times, want 1", n)
+	}
+
+	// a key must be used with its algorithm
+	if _, err := v.Verify(sign(t, jwt.SigningMethodRS512, "rsa", rsaKey, "alice", "jwt-go-example")); err == nil {
+		t.Error("a token signed with another algorithm than the one of its key was accepted")
+	}
+	// an unknown key is fetched again at most once a minute
+	jwks.triedAt = time.Now().Add(-2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(sign(t, jwt.SigningMethodEdDSA, "other", edKey, "alice", "jwt-go-example")); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("unknown key: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched the keys %d times, want 2", n)
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	down := &Verifier{Issuer: "jwt-go-example", Audience: "jwt-go-example", Keyfunc: NewJWKS(missing.URL).Keyfunc}
+	if _, err := down.Verify(sign(t, jwt.SigningMethodEdDSA, "ed", edKey, "alice", "jwt-go-example")); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("keys unavailable: %v", err)
+	}
+}
+
+func TestJWKSFetchOutsideTheLock(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := []jwk{{Kty: "OKP", Kid: "ed", Alg: "EdDSA", Crv: "Ed25519", X: b64(edKey.Public().(ed25519.PublicKey))}}
+	var fetches atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the second fetch hangs until the test releases it
+		if fetches.Add(1) == 2 {
+			close(started)
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": keys})
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL)
+	v := &Verifier{Issuer: "jwt-go-example", Audience: "jwt-go-example", Keyfunc: jwks.Keyfunc}
+	known := sign(t, jwt.SigningMethodEdDSA, "ed", edKey, "alice", "jwt-go-example")
+	unknown := sign(t, jwt.SigningMethodEdDSA, "other", edKey, "alice", "jwt-go-example")
+	if _, err := v.Verify(known); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks.mu.Lock()
+	jwks.triedAt = time.Now().Add(-2 * time.Minute)
+	jwks.mu.Unlock()
+	errs := make(chan error, 2)
+	go func() {
+		_, err := v.Verify(unknown)
+		errs <- err
+	}()
+	<-started
+	// a second unknown key waits for the fetch in progress instead of
+	// starting another one
+	go func() {
+		_, err := v.Verify(unknown)
+		errs <- err
+	}()
+
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(known)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("known key during a fetch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a token of a known key waited for the fetch")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("unknown key: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched the keys %d times, want 2", n)
+	}
+}
+
+func TestIntrospector(t *testing.T) {
+	var calls atomic.Int32
+	revoked := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if id, secret, _ := r.BasicAuth(); id != "notes" || secret != "secret" {
+			http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"active": !revoked[r.PostFormValue("token")]})
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	i := NewIntrospector(server.URL, "notes", "secret")
+	revoked["old"] = true
+	if err := i.Check(ctx, "old", expiresAt); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revoked token: %v", err)
+	}
+	if err := i.Check(ctx, "new", expiresAt); err != nil {
+		t.Errorf("active token: %v", err)
+	}
+
+	// the answers are cached until they are introspectionTTL old
+	revoked["new"] = true
+	if err := i.Check(ctx, "new", expiresAt); err != nil || calls.Load() != 2 {
+		t.Errorf("cached answer: %v after %d calls", err, calls.Load())
+	}
+	i.mu.Lock()
+	for k, c := range i.cache {
+		c.until = time.Now().Add(-time.Second)
+		i.cache[k] = c
+	}
+	i.mu.Unlock()
+	if err := i.Check(ctx, "new", expiresAt); !errors.Is(err, ErrTokenRevoked) || calls.Load() != 3 {
+		t.Errorf("after the cache expired: %v after %d calls", err, calls.Load())
+	}
+
+	wrong := NewIntrospector(server.URL, "notes", "wrong")
+	if err := wrong.Check(ctx, "new", expiresAt); !errors.Is(err, ErrIntrospectionUnavailable) {
+		t.Errorf("wrong client secret: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// introspectionTTL is how long the answers of the introspection endpoint
+// are cached, and so how long a revoked token may still be accepted
+const introspectionTTL = 30 * time.Second
+
+var (
+	ErrTokenRevoked = errors.New("the token has been revoked")
+	// ErrIntrospectionUnavailable is returned while jwt-go-example cannot
+	// tell whether a token is active
+	ErrIntrospectionUnavailable = errors.New("the tokens cannot be introspected")
+)
+
+// introspection is a cached answer of the introspection endpoint
+type introspection struct {
+	active bool
+	until  time.Time
+}
+
+// Introspector asks jwt-go-example whether the tokens are still active with
+// its /oauth/introspect endpoint (RFC 7662), authenticating as one of its
+// OAuth clients. The tokens revoked by a logout, the deletion of their
+// session, the reuse of a refresh token or /oauth/revoke are inactive.
+type Introspector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu sync.Mutex
+	// cache holds the answers by the SHA-256 of the token
+	cache map[[sha256.Size]byte]introspection
+}
+
+// NewIntrospector returns an Introspector for the endpoint at url, such as
+// http://localhost:8080/oauth/introspect for jwt-go-example
+func NewIntrospector(url, clientID, clientSecret string) *Introspector {
+	return &Introspector{
+		url:          url,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		cache:        map[[sha256.Size]byte]introspection{},
+	}
+}
+
+// Check returns ErrTokenRevoked if the token, which expires at expiresAt,
+// is no longer active. The answers are cached for introspectionTTL.
+func (i *Introspector) Check(ctx context.Context, token string, expiresAt time.Time) error {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	i.mu.Lock()
+	cached, ok := i.cache[key]
+	i.mu.Unlock()
+	if !ok || now.After(cached.until) {
+		active, err := i.introspect(ctx, token)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrIntrospectionUnavailable, err)
+		}
+		cached = introspection{active: active, until: now.Add(introspectionTTL)}
+		if expiresAt.Before(cached.until) {
+			cached.until = expiresAt
+		}
+		i.mu.Lock()
+		for k, c := range i.cache {
+			if now.After(c.until) {
+				delete(i.cache, k)
+			}
+		}
+		i.cache[key] = cached
+		i.mu.Unlock()
+	}
+	if !cached.active {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// introspect asks the endpoint whether the token is active
+func (i *Introspector) introspect(ctx context.Context, token string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(i.clientID, i.clientSecret)
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("POST %s: %s", i.url, resp.Status)
+	}
+	var answer struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return false, fmt.Errorf("POST %s: %w", i.url, err)
+	}
+	return answer.Active, nil
+}
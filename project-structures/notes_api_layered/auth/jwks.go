@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// How often the keys are fetched again: every refreshInterval, and when a
+// token names an unknown key. A fetch is tried at most every refetchInterval.
+const (
+	refreshInterval = 5 * time.Minute
+	refetchInterval = time.Minute
+)
+
+var (
+	ErrUnknownKey = errors.New("the token is signed by an unknown key")
+	// ErrKeysUnavailable is returned while the keys cannot be fetched
+	ErrKeysUnavailable = errors.New("the signing keys cannot be fetched")
+)
+
+// jwk is a public key in a JSON Web Key Set, as jwt-go-example publishes it
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a key of the set along with its algorithm
+type publicKey struct {
+	alg string
+	key any
+}
+
+// JWKS is the key set published at a URL, fetched on the first token and
+// cached
+type JWKS struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+	triedAt   time.Time
+	// fetching is closed when the fetch in progress ends, and fetchErr is
+	// the error of the last fetch
+	fetching chan struct{}
+	fetchErr error
+}
+
+// NewJWKS returns the key set published at url, such as
+// http://localhost:8080/.well-known/jwks.json for jwt-go-example
+func NewJWKS(url string) *JWKS {
+	return &JWKS{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Keyfunc returns the public key named by the "kid" header of the token,
+// fetching the set again when it is old or does not have the key. If the
+// set cannot be fetched, the keys already known are used.
+func (s *JWKS) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, known, err := s.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, ErrUnknownKey
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// key looks up kid in the set, fetching it first if needed. The lock is not
+// held during the fetch: the tokens of known keys are verified meanwhile
+// with the keys already known, and the others wait for the fetch.
+func (s *JWKS) key(kid string) (publicKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, known := s.keys[kid]
+	now := time.Now()
+	switch {
+	case s.fetching != nil:
+		if known {
+			return key, true, nil
+		}
+		done := s.fetching
+		s.mu.Unlock()
+		<-done
+		s.mu.Lock()
+	case now.Sub(s.triedAt) > refetchInterval && (!known || now.Sub(s.fetchedAt) > refreshInterval):
+		done := make(chan struct{})
+		s.fetching, s.triedAt = done, now
+		s.mu.Unlock()
+		keys, err := s.fetch()
+		s.mu.Lock()
+		s.fetching, s.fetchErr = nil, err
+		close(done)
+		if err == nil {
+			s.keys, s.fetchedAt = keys, now
+		}
+	}
+	if s.keys == nil {
+		return publicKey{}, false, fmt.Errorf("%w: %v", ErrKeysUnavailable, s.fetchErr)
+	}
+	key, known = s.keys[kid]
+	return key, known, nil
+}
+
+// fetch downloads and parses the key set. The keys of unknown types are
+// skipped.
+func (s *JWKS) fetch() (map[string]publicKey, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", s.url, resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", s.url, err)
+	}
+	keys := map[string]publicKey{}
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+		}
+	}
+	return keys, nil
+}
+
+// publicKey decodes an RSA, EC or Ed25519 key, and returns nil for the
+// other types
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unknown curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unknown curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// decodeInt decodes a base64url big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
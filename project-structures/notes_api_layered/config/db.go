@@ -2,15 +2,147 @@ package config
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	// Importing pgx v5 for PostgreSQL, registered as the "pgx" driver of database/sql
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// Config selects where the notes are stored
+type Config struct {
+	// Driver is sqlite, postgres or memory
+	Driver string
+	// DSN is the file of the SQLite database or the URL of the PostgreSQL one
+	DSN string
+	// TrashRetention is how long the deleted notes stay in the trash, zero
+	// keeping them forever, and PurgeInterval how often the trash is purged
+	TrashRetention time.Duration
+	PurgeInterval  time.Duration
+	// JWKSURL is where the public keys of the tokens are published, and
+	// JWTIssuer and JWTAudience what the tokens must be issued by and for
+	JWKSURL     string
+	JWTIssuer   string
+	JWTAudience string
+	// IntrospectionURL is where the tokens are checked for revocation, as
+	// the OAuth client ClientID of jwt-go-example. Without a ClientID, they
+	// are not checked.
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+	// LegacyOwner gets the notes written before they had owners at startup
+	LegacyOwner string
+}
+
+// LoadConfig reads the configuration from the environment:
+// NOTES_DB_DRIVER (sqlite by default) and NOTES_DB_DSN, which defaults to
+// ./notesapi.db for SQLite and to DATABASE_URL for PostgreSQL.
+// NOTES_TRASH_RETENTION_DAYS (30 by default) and NOTES_PURGE_INTERVAL
+// (1h by default) configure the purge of the trash.
+// NOTES_JWKS_URL, NOTES_JWT_ISSUER and NOTES_JWT_AUDIENCE configure the
+// tokens, which are those of jwt-go-example running on localhost:8080 by
+// default. NOTES_INTROSPECTION_URL, NOTES_CLIENT_ID and NOTES_CLIENT_SECRET
+// configure their revocation check, which is off without a client ID.
+// NOTES_LEGACY_OWNER is the user who gets the notes without an owner.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Driver:         os.Getenv("NOTES_DB_DRIVER"),
+		DSN:            os.Getenv("NOTES_DB_DSN"),
+		TrashRetention: 30 * 24 * time.Hour,
+		PurgeInterval:  time.Hour,
+		JWKSURL:        envOr("NOTES_JWKS_URL", "http://localhost:8080/.well-known/jwks.json"),
+		JWTIssuer:      envOr("NOTES_JWT_ISSUER", "jwt-go-example"),
+		JWTAudience:    envOr("NOTES_JWT_AUDIENCE", "jwt-go-example"),
 
-func InitializeDB() (*sql.DB, error) {
+		IntrospectionURL: envOr("NOTES_INTROSPECTION_URL", "http://localhost:8080/oauth/introspect"),
+		ClientID:         os.Getenv("NOTES_CLIENT_ID"),
+		ClientSecret:     os.Getenv("NOTES_CLIENT_SECRET"),
+		LegacyOwner:      os.Getenv("NOTES_LEGACY_OWNER"),
+	}
+	if cfg.Driver == "" {
+		cfg.Driver = "sqlite"
+	}
+	if cfg.DSN == "" {
+		switch cfg.Driver {
+		case "sqlite":
+			cfg.DSN = "./notesapi.db"
+		case "postgres":
+			cfg.DSN = os.Getenv("DATABASE_URL")
+		}
+	}
+	if s := os.Getenv("NOTES_TRASH_RETENTION_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 {
+			return cfg, fmt.Errorf("NOTES_TRASH_RETENTION_DAYS must be a number of days, got %q", s)
+		}
+		cfg.TrashRetention = time.Duration(days) * 24 * time.Hour
+	}
+	if s := os.Getenv("NOTES_PURGE_INTERVAL"); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval <= 0 {
+			return cfg, fmt.Errorf("NOTES_PURGE_INTERVAL must be a positive duration such as 30m, got %q", s)
+		}
+		cfg.PurgeInterval = interval
+	}
+	return cfg, nil
+}
+
+// envOr returns the environment variable key, or def when it is empty
+func envOr(key, def string) string {
+	if s := os.Getenv(key); s != "" {
+		return s
+	}
+	return def
+}
+
+// ErrNoFTS5 is returned by InitializeDB when SQLite is built without FTS5,
+// which the search and the triggers of the notes table need
+var ErrNoFTS5 = errors.New("SQLite is built without FTS5, build with -tags sqlite_fts5 or make")
+
+// InitializeDB opens the database of cfg. The memory driver has none, and
+// gets a nil *sql.DB.
+func InitializeDB(cfg Config) (*sql.DB, error) {
 	// Initialize connection to the database
-	var err error
-	DB, err = sql.Open("sqlite3", "./notesapi.db")
-	return DB, err
+	switch cfg.Driver {
+	case "sqlite":
+		db, err := sql.Open("sqlite3", sqliteDSN(cfg.DSN))
+		if err != nil {
+			return nil, err
+		}
+		// go-sqlite3 only defines SQLITE_ENABLE_FTS5 with the sqlite_fts5 tag
+		var fts5 bool
+		if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if !fts5 {
+			db.Close()
+			return nil, ErrNoFTS5
+		}
+		return db, nil
+	case "postgres":
+		return sql.Open("pgx", cfg.DSN)
+	case "memory":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q, use sqlite, postgres or memory", cfg.Driver)
+}
+
+// sqliteDSN makes the transactions on the SQLite database dsn take the write
+// lock when they begin, unless it sets _txlock itself. A deferred
+// transaction that reads before writing fails at once with SQLITE_BUSY when
+// another one writes meanwhile, without waiting for the busy timeout.
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_txlock=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_txlock=immediate"
+	}
+	return dsn + "?_txlock=immediate"
 }
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+// The limits on the size of an import: the whole request, the Markdown
+// files of a zip once uncompressed, and each of them, which is a body of
+// at most MaxBodyLength characters of up to 4 bytes after its front matter
+const (
+	maxImportSize       = 32 << 20
+	maxUncompressedSize = 64 << 20
+	maxFrontMatterSize  = 8 << 10
+	maxMarkdownSize     = 4*models.MaxBodyLength + maxFrontMatterSize
+)
+
+// markdownFileName names the Markdown file of a note in an export: its id,
+// then the ASCII letters and digits of its title, in words joined by dashes
+func markdownFileName(note *models.Note) string {
+	words := strings.FieldsFunc(strings.ToLower(note.Title), func(r rune) bool {
+		return !('a' <= r && r <= 'z') && !('0' <= r && r <= '9')
+	})
+	slug := strings.Join(words, "-")
+	if len(slug) > 48 {
+		slug = strings.TrimRight(slug[:48], "-")
+	}
+	if slug == "" {
+		return fmt.Sprintf("%04d.md", note.Id)
+	}
+	return fmt.Sprintf("%04d-%s.md", note.Id, slug)
+}
+
+// ExportNotes streams a zip of the notes as Markdown files, with the
+// filters of GetAllNotes. The first page of notes is read before answering,
+// so that its errors are answered as problems. A later error cuts the zip,
+// which the client sees as a broken archive.
+func (nc *NoteController) ExportNotes(c *gin.Context) {
+	params, err := parseListParams(c)
+	if err != nil {
+		writeError(c, problem.Wrap(problem.ErrBadRequest, err))
+		return
+	}
+	params.Limit, params.Offset, params.Cursor = models.MaxLimit, 0, ""
+	params.Sort, params.Order = "created_at", "asc"
+	page, err := nc.Notes.List(c.Request.Context(), params)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="notes.zip"`)
+	c.Status(http.StatusOK)
+	archive := zip.NewWriter(c.Writer)
+	for {
+		for i := range page.Notes {
+			if err := writeMarkdownFile(archive, &page.Notes[i]); err != nil {
+				log.Println("Exporting the notes failed:", err)
+				return
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.NextCursor
+		if page, err = nc.Notes.List(c.Request.Context(), params); err != nil {
+			log.Println("Exporting the notes failed:", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Println("Exporting the notes failed:", err)
+	}
+}
+
+// writeMarkdownFile adds a note to the zip
+func writeMarkdownFile(archive *zip.Writer, note *models.Note) error {
+	data, err := models.MarshalMarkdown(note)
+	if err != nil {
+		return err
+	}
+	w, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     markdownFileName(note),
+		Method:   zip.Deflate,
+		Modified: note.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// importedFile is the outcome of the import of one file or array element
+type importedFile struct {
+	File  string `json:"file"`
+	Id    int    `json:"id,omitempty"`
+	Title string `json:"title"`
+}
+
+// ImportNotes creates the notes of a zip of Markdown files, as exported by
+// ExportNotes, or of a JSON array of notes. The notes are checked first,
+// and the problems of every file are answered together with 422, in fields
+// named after the files. Otherwise all the notes are created in one
+// transaction. With dry_run=true, they are only checked.
+func (nc *NoteController) ImportNotes(c *gin.Context) {
+	dryRun := false
+	if s := c.Query("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			var errs models.ValidationError
+			errs.Add("dry_run", "must be true or false")
+			writeError(c, problem.Wrap(problem.ErrBadRequest, &errs))
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Error(c.Writer, c.Request, http.StatusRequestEntityTooLarge, "",
+			fmt.Sprintf("the import must be at most %d MiB", maxImportSize>>20))
+		return
+	} else if err != nil {
+		writeError(c, problem.Wrap(problem.ErrBadRequest, err))
+		return
+	}
+
+	var files []importedFile
+	var notes []models.NoteImport
+	var errs models.ValidationError
+	// add checks a note read from a file, recording its problems under the
+	// name of the file
+	add := func(file string, note *models.NoteImport, err error) {
+		if err == nil {
+			note.Normalize()
+			err = note.Validate()
+		}
+		var invalid *models.ValidationError
+		switch {
+		case errors.As(err, &invalid):
+			for _, fe := range invalid.Errors {
+				errs.Add(file+": "+fe.Field, fe.Message)
+			}
+		case err != nil:
+			errs.Add(file, err.Error())
+		default:
+			files = append(files, importedFile{File: file, Title: note.Title})
+			notes = append(notes, *note)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	switch mediaType {
+	case "application/zip", "application/x-zip-compressed":
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			writeError(c, problem.New(problem.ErrBadRequest, "the request body is not a valid zip archive"))
+			return
+		}
+		// the directories, the files that are not Markdown and the hidden
+		// ones, such as the __MACOSX folder of the macOS archiver, are skipped
+		var markdown []*zip.File
+		for _, f := range archive.File {
+			if !f.FileInfo().IsDir() && path.Ext(f.Name) == ".md" && !strings.HasPrefix(f.Name, "__MACOSX/") && !strings.HasPrefix(path.Base(f.Name), ".") {
+				markdown = append(markdown, f)
+			}
+		}
+		// archive/zip refuses the files longer than their header says, so
+		// the sizes of the headers can be trusted
+		var size uint64
+		for _, f := range markdown {
+			size += f.UncompressedSize64
+		}
+		if len(markdown) > models.MaxImportNotes {
+			errs.Add("files", fmt.Sprintf("must be at most %d Markdown files", models.MaxImportNotes))
+			markdown = nil
+		} else if size > maxUncompressedSize {
+			errs.Add("files", fmt.Sprintf("must be at most %d MiB once uncompressed", maxUncompressedSize>>20))
+			markdown = nil
+		}
+		for _, f := range markdown {
+			data, err := readZipFile(f)
+			if err != nil {
+				add(f.Name, nil, err)
+				continue
+			}
+			note, err := models.ParseMarkdown(data)
+			add(f.Name, note, err)
+		}
+	case "application/json", "":
+		var array []json.RawMessage
+		if err := json.Unmarshal(body, &array); err != nil {
+			writeError(c, problem.New(problem.ErrBadRequest, "the request body must be a JSON array of notes or a zip of Markdown files"))
+			return
+		}
+		if len(array) > models.MaxImportNotes {
+			errs.Add("notes", fmt.Sprintf("must have at most %d notes", models.MaxImportNotes))
+			array = nil
+		}
+		for i, raw := range array {
+			var note models.NoteImport
+			err := json.Unmarshal(raw, &note)
+			var typeErr *json.UnmarshalTypeError
+			var timeErr *time.ParseError
+			switch {
+			case errors.As(err, &typeErr) && typeErr.Field != "":
+				var invalid models.ValidationError
+				invalid.Add(typeErr.Field, "must be "+jsonType(typeErr.Type.Kind()))
+				err = &invalid
+			case errors.As(err, &timeErr):
+				err = errors.New("created_at and updated_at must be RFC 3339 times")
+			case err != nil:
+				err = errors.New("must be a JSON object")
+			}
+			add(fmt.Sprintf("notes[%d]", i), &note, err)
+		}
+	default:
+		problem.Error(c.Writer, c.Request, http.StatusUnsupportedMediaType, "",
+			"the import must be a zip of Markdown files, in application/zip, or a JSON array of notes, in application/json")
+		return
+	}
+
+	if err := errs.Err(); err != nil {
+		writeError(c, err)
+		return
+	}
+	if len(notes) == 0 {
+		writeError(c, problem.New(problem.ErrValidation, "there are no notes to import"))
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Notes checked, nothing was imported",
+			"dry_run": true,
+			"files":   files,
+		})
+		return
+	}
+	imported, err := nc.Notes.Import(c.Request.Context(), notes)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	for i := range files {
+		files[i].Id = imported[i].Id
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Notes imported successfully",
+		"dry_run": false,
+		"files":   files,
+	})
+}
+
+// readZipFile reads a file of an archive, refusing the ones larger than
+// maxMarkdownSize once uncompressed without decompressing them
+func readZipFile(f *zip.File) ([]byte, error) {
+	tooLarge := fmt.Errorf("must be at most %d KiB once uncompressed", maxMarkdownSize>>10)
+	if f.UncompressedSize64 > maxMarkdownSize {
+		return nil, tooLarge
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxMarkdownSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMarkdownSize {
+		return nil, tooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/favtuts/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/auth"
+	"github.com/username/notes_api_layered/models"
+)
+
+var (
+	errTokenRequired = problem.New(problem.ErrUnauthorized, "a bearer token from jwt-go-example is required")
+	errInvalidToken  = problem.New(problem.ErrUnauthorized, "the bearer token is invalid or expired")
+	errRevokedToken  = problem.New(problem.ErrUnauthorized, "the bearer token has been revoked")
+)
+
+// The scopes of the tokens needed to read the notes, and to change them
+const (
+	scopeRead  = "notes:read"
+	scopeWrite = "notes:write"
+)
+
+// RequireUser authenticates the requests with the bearer token of their
+// Authorization header, and acts for the subject of the token in the
+// repository. The requests without a valid token are answered 401, and
+// the ones whose token lacks the scope of their method 403: GET and HEAD
+// need notes:read, the other methods notes:write.
+//
+// The tokens revoked in jwt-go-example, by a logout or the end of their
+// session, are refused when the verifier has an Introspector, at most
+// 30 seconds after their revocation. They are accepted until they expire
+// otherwise, since the denylist of their jti and sid is not published.
+func RequireUser(verifier *auth.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="notes"`)
+			writeError(c, errTokenRequired)
+			c.Abort()
+			return
+		}
+		claims, err := verifier.Verify(token)
+		switch {
+		case errors.Is(err, auth.ErrKeysUnavailable):
+			log.Println("Verifying a token failed:", err)
+			problem.Error(c.Writer, c.Request, http.StatusServiceUnavailable, "",
+				"the signing keys of the tokens cannot be fetched, try again later")
+			c.Abort()
+			return
+		case err != nil:
+			c.Header("WWW-Authenticate", `Bearer realm="notes", error="invalid_token"`)
+			writeError(c, errInvalidToken)
+			c.Abort()
+			return
+		}
+		if verifier.Introspector != nil {
+			err := verifier.Introspector.Check(c.Request.Context(), token, claims.ExpiresAt.Time)
+			switch {
+			case errors.Is(err, auth.ErrTokenRevoked):
+				c.Header("WWW-Authenticate", `Bearer realm="notes", error="invalid_token"`)
+				writeError(c, errRevokedToken)
+				c.Abort()
+				return
+			case err != nil:
+				log.Println("Introspecting a token failed:", err)
+				problem.Error(c.Writer, c.Request, http.StatusServiceUnavailable, "",
+					"the tokens cannot be checked for revocation, try again later")
+				c.Abort()
+				return
+			}
+		}
+		scope := scopeWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = scopeRead
+		}
+		if !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", `Bearer realm="notes", error="insufficient_scope", scope="`+scope+`"`)
+			writeError(c, problem.New(problem.ErrForbidden, "the token lacks the "+scope+" scope"))
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(models.WithUser(c.Request.Context(), claims.Subject))
+		c.Next()
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/favtuts/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+var errIfMatchRequired = problem.New(problem.ErrPreconditionRequired,
+	"the If-Match header must give the ETag of the note, or * to change any version")
+
+// etag is the entity tag of a version of a note: the version, quoted
+func etag(note *models.Note) string {
+	return `"` + strconv.Itoa(note.Version) + `"`
+}
+
+// setETag sends the entity tag of the note in the ETag header
+func setETag(c *gin.Context, note *models.Note) {
+	c.Header("ETag", etag(note))
+}
+
+// entityTags splits an If-Match or If-None-Match header into its entity tags
+func entityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ifMatch returns the version of the note the If-Match header allows to
+// change, or answers 428 when the header is missing. With * it is
+// models.AnyVersion. With several entity tags it is the current version of
+// the note if one of them matches it. A weak entity tag never matches.
+func (nc *NoteController) ifMatch(c *gin.Context, id int) (int, bool) {
+	header := c.GetHeader("If-Match")
+	if strings.TrimSpace(header) == "" {
+		writeError(c, errIfMatchRequired)
+		return 0, false
+	}
+	var versions []int
+	for _, tag := range entityTags(header) {
+		if tag == "*" {
+			return models.AnyVersion, true
+		}
+		unquoted := strings.TrimSuffix(strings.TrimPrefix(tag, `"`), `"`)
+		if version, err := strconv.Atoi(unquoted); err == nil && version > 0 && len(unquoted)+2 == len(tag) {
+			versions = append(versions, version)
+		}
+	}
+	switch len(versions) {
+	case 0:
+		// no version can match, but a missing note is still a 404
+		if _, err := nc.Notes.Get(c.Request.Context(), id); err != nil {
+			writeError(c, err)
+		} else {
+			writeError(c, models.ErrVersionMismatch)
+		}
+		return 0, false
+	case 1:
+		return versions[0], true
+	}
+	note, err := nc.Notes.Get(c.Request.Context(), id)
+	if err != nil {
+		writeError(c, err)
+		return 0, false
+	}
+	for _, version := range versions {
+		if version == note.Version {
+			return version, true
+		}
+	}
+	writeError(c, models.ErrVersionMismatch)
+	return 0, false
+}
+
+// notModified answers 304 when the If-None-Match header matches the note,
+// comparing the entity tags weakly as RFC 9110 asks for GET
+func notModified(c *gin.Context, note *models.Note) bool {
+	current := etag(note)
+	for _, tag := range entityTags(c.GetHeader("If-None-Match")) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			setETag(c, note)
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
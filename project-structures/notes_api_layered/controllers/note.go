@@ -1,54 +1,230 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/favtuts/problem"
 	"github.com/gin-gonic/gin"
 	"github.com/username/notes_api_layered/models"
 )
 
-type NoteController struct{}
+// NoteController serves the notes stored in its repository
+type NoteController struct {
+	Notes models.NoteRepository
+}
+
+func NewNoteController(notes models.NoteRepository) *NoteController {
+	return &NoteController{Notes: notes}
+}
 
-func (_ *NoteController) CreateNewNote(c *gin.Context) {
+func (nc *NoteController) CreateNewNote(c *gin.Context) {
 	var params models.NoteParams
-	var note models.Note
-	err := c.BindJSON(&params)
-	if err == nil {
-		_, creationError := note.Create(params)
-		if creationError == nil {
-			c.JSON(http.StatusCreated, gin.H{
-				"message": "Note created successfully",
-				"note":    note,
-			})
-		} else {
-			c.String(http.StatusInternalServerError, creationError.Error())
-		}
+	if !bindNote(c, &params) {
+		return
+	}
+	note, err := nc.Notes.Create(c.Request.Context(), params)
+	if err == nil {
+		setETag(c, note)
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Note created successfully",
+			"note":    note,
+		})
 	} else {
-		c.String(http.StatusInternalServerError, err.Error())
+		writeError(c, err)
 	}
 }
-func (_ *NoteController) GetAllNotes(c *gin.Context) {
-	var note models.Note
-	notes, err := note.GetAll()
+func (nc *NoteController) GetAllNotes(c *gin.Context) {
+	nc.listNotes(c, false, false, "All Notes")
+}
+
+// GetTrash lists the deleted notes, with the query parameters of GetAllNotes
+func (nc *NoteController) GetTrash(c *gin.Context) {
+	nc.listNotes(c, true, false, "Trashed Notes")
+}
+
+// GetSharedNotes lists the notes other users share with the user, with the
+// query parameters of GetAllNotes
+func (nc *NoteController) GetSharedNotes(c *gin.Context) {
+	nc.listNotes(c, false, true, "Shared Notes")
+}
+
+func (nc *NoteController) listNotes(c *gin.Context, trashed, shared bool, message string) {
+	params, err := parseListParams(c)
+	if err != nil {
+		writeError(c, problem.Wrap(problem.ErrBadRequest, err))
+		return
+	}
+	params.Trashed, params.Shared = trashed, shared
+	page, err := nc.Notes.List(c.Request.Context(), params)
 	if err == nil {
+		if links := paginationLinks(c.Request.URL, params, page); links != "" {
+			c.Header("Link", links)
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"message": "All Notes",
-			"notes":   notes,
+			"message": message,
+			"notes":   page.Notes,
+			"pagination": gin.H{
+				"total":  page.Total,
+				"limit":  params.Limit,
+				"offset": params.Offset,
+				"sort":   params.Sort,
+				"order":  params.Order,
+				"next":   page.NextCursor,
+			},
 		})
 	} else {
-		c.String(http.StatusInternalServerError, err.Error())
+		writeError(c, err)
 	}
 }
-func (_ *NoteController) GetSingleNote(c *gin.Context) {
-	var note models.Note
-	id := c.Param("note_id")
-	_, err := note.Fetch(id)
+func (nc *NoteController) GetSingleNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	note, err := nc.Notes.Get(c.Request.Context(), id)
 	if err == nil {
+		if notModified(c, note) {
+			return
+		}
+		setETag(c, note)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Single Note",
 			"note":    note,
 		})
 	} else {
-		c.String(http.StatusInternalServerError, err.Error())
+		writeError(c, err)
 	}
 }
+func (nc *NoteController) SearchNotes(c *gin.Context) {
+	var errs models.ValidationError
+	limit := 0
+	if s := c.Query("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > models.MaxLimit {
+			errs.Add("limit", fmt.Sprintf("must be between 1 and %d", models.MaxLimit))
+		}
+	}
+	if strings.TrimSpace(c.Query("q")) == "" {
+		errs.Add("q", "is required")
+	}
+	if err := errs.Err(); err != nil {
+		writeError(c, problem.Wrap(problem.ErrBadRequest, err))
+		return
+	}
+	results, err := nc.Notes.Search(c.Request.Context(), c.Query("q"), limit)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Search Results",
+			"results": results,
+		})
+	} else if errors.Is(err, models.ErrEmptyQuery) {
+		errs.Add("q", "has no word to search for")
+		writeError(c, problem.Wrap(problem.ErrBadRequest, &errs))
+	} else {
+		writeError(c, err)
+	}
+}
+func (nc *NoteController) UpdateNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	version, ok := nc.ifMatch(c, id)
+	if !ok {
+		return
+	}
+	var params models.NoteParams
+	if !bindNote(c, &params) {
+		return
+	}
+	note, err := nc.Notes.Update(c.Request.Context(), id, version, params)
+	if err == nil {
+		setETag(c, note)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note updated successfully",
+			"note":    note,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+func (nc *NoteController) PatchNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	version, ok := nc.ifMatch(c, id)
+	if !ok {
+		return
+	}
+	var params models.NotePatch
+	if !bindNote(c, &params) {
+		return
+	}
+	note, err := nc.Notes.Patch(c.Request.Context(), id, version, params)
+	if err == nil {
+		setETag(c, note)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note updated successfully",
+			"note":    note,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+func (nc *NoteController) DeleteNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	version, ok := nc.ifMatch(c, id)
+	if !ok {
+		return
+	}
+	err := nc.Notes.Delete(c.Request.Context(), id, version)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note deleted successfully",
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// RestoreNote takes a deleted note out of the trash
+func (nc *NoteController) RestoreNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	note, err := nc.Notes.Undelete(c.Request.Context(), id)
+	if err == nil {
+		setETag(c, note)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note restored successfully",
+			"note":    note,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// noteId reads the id of the note from the path. An id that is not a number
+// cannot match any note, and is answered with 404.
+func noteId(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("note_id"))
+	if err != nil {
+		writeError(c, models.ErrNoteNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeError answers err as a problem, with the status code of its kind
+func writeError(c *gin.Context, err error) {
+	problem.Write(c.Writer, c.Request, err)
+}
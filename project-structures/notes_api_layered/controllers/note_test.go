@@ -0,0 +1,723 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/favtuts/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/username/notes_api_layered/auth"
+	"github.com/username/notes_api_layered/models"
+)
+
+// testKey signs the tokens of the tests, as jwt-go-example would
+var _, testKey, _ = ed25519.GenerateKey(rand.Reader)
+
+// tokenFor returns a token for the user, valid for an hour, with the scopes
+// to read and change notes
+func tokenFor(user string) string {
+	return tokenWithScope(user, "notes:read notes:write")
+}
+
+func tokenWithScope(user, scope string) string {
+	now := time.Now()
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &auth.Claims{
+		Username: user,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "jwt-go-example",
+			Subject:   user,
+			Audience:  jwt.ClaimStrings{"jwt-go-example"},
+		},
+	}).SignedString(testKey)
+	return token
+}
+
+// newTestRouter serves the routes of main.go from a memory repository
+func newTestRouter() *gin.Engine {
+	return newTestRouterWith(models.NewMemoryNoteRepository())
+}
+
+func newTestRouterWith(notes models.NoteRepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	nc := NewNoteController(notes)
+	router.GET("/shared/:token", nc.GetSharedNote)
+	user := router.Group("/", RequireUser(&auth.Verifier{
+		Issuer:   "jwt-go-example",
+		Audience: "jwt-go-example",
+		Keyfunc:  func(*jwt.Token) (any, error) { return testKey.Public(), nil },
+	}))
+	user.GET("/notes", nc.GetAllNotes)
+	user.POST("/notes", nc.CreateNewNote)
+	user.GET("/notes/search", nc.SearchNotes)
+	user.GET("/notes/trash", nc.GetTrash)
+	user.GET("/notes/shared", nc.GetSharedNotes)
+	user.GET("/notes/export", nc.ExportNotes)
+	user.POST("/notes/import", nc.ImportNotes)
+	user.GET("/notes/:note_id", nc.GetSingleNote)
+	user.PUT("/notes/:note_id", nc.UpdateNote)
+	user.PATCH("/notes/:note_id", nc.PatchNote)
+	user.DELETE("/notes/:note_id", nc.DeleteNote)
+	user.POST("/notes/:note_id/restore", nc.RestoreNote)
+	user.GET("/notes/:note_id/revisions", nc.GetRevisions)
+	user.GET("/notes/:note_id/revisions/:revision", nc.GetSingleRevision)
+	user.GET("/notes/:note_id/revisions/:revision/diff", nc.DiffRevision)
+	user.POST("/notes/:note_id/revisions/:revision/restore", nc.RestoreRevision)
+	user.GET("/notes/:note_id/shares", nc.GetShares)
+	user.PUT("/notes/:note_id/shares/:username", nc.ShareNote)
+	user.DELETE("/notes/:note_id/shares/:username", nc.UnshareNote)
+	user.GET("/notes/:note_id/links", nc.GetLinks)
+	user.POST("/notes/:note_id/links", nc.CreateLink)
+	user.DELETE("/notes/:note_id/links/:link_id", nc.DeleteLink)
+	user.GET("/tags", nc.GetAllTags)
+	return router
+}
+
+// serve sends a request as alice with If-Match: *, changing any version of
+// the note
+func serve(router *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+	return serveAs(router, "alice", method, target, body)
+}
+
+// serveAs sends a request as the user with If-Match: *, or without a token
+// when the user is empty
+func serveAs(router *gin.Engine, user, method, target, body string) *httptest.ResponseRecorder {
+	return send(router, user, method, target, body, "If-Match", "*")
+}
+
+// serveIf sends a request as alice with the header given, which is left
+// out when empty
+func serveIf(router *gin.Engine, method, target, body, header, value string) *httptest.ResponseRecorder {
+	return send(router, "alice", method, target, body, header, value)
+}
+
+func send(router *gin.Engine, user, method, target, body, header, value string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		r.Header.Set("Authorization", "Bearer "+tokenFor(user))
+	}
+	if value != "" {
+		r.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestNoteRoutes(t *testing.T) {
+	router := newTestRouter()
+
+	w := serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Brewing espresso"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var created struct{ Note models.Note }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Note.Id != 1 {
+		t.Fatalf("create: got %s", w.Body)
+	}
+
+	tests := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{"GET", "/notes/1", "", http.StatusOK},
+		{"PATCH", "/notes/1", `{"title": "Espresso"}`, http.StatusOK},
+		{"GET", "/notes?limit=1&sort=title", "", http.StatusOK},
+		{"GET", "/notes?sort=body", "", http.StatusBadRequest},
+		{"GET", "/notes/search?q=espresso", "", http.StatusOK},
+		{"GET", "/notes/search?q=", "", http.StatusBadRequest},
+		{"GET", "/notes/abc", "", http.StatusNotFound},
+		{"PUT", "/notes/2", `{"title": "a", "body": "b"}`, http.StatusNotFound},
+		{"DELETE", "/notes/1", "", http.StatusOK},
+		{"GET", "/notes/1", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(router, tt.method, tt.target, tt.body); w.Code != tt.code {
+			t.Errorf("%s %s: got %d %s, want %d", tt.method, tt.target, w.Code, w.Body, tt.code)
+		}
+	}
+}
+
+func TestNoteValidation(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Brewing espresso"}`)
+
+	tests := []struct {
+		method, target, body string
+		code                 int
+		errors               string
+	}{
+		{"POST", "/notes", `{"title": "  ", "body": "text"}`, http.StatusUnprocessableEntity, "title: is required"},
+		{"POST", "/notes", `{"title": "` + strings.Repeat("é", 65) + `"}`, http.StatusUnprocessableEntity, "title: must be at most 64 characters"},
+		{"POST", "/notes", `{"title": "two\nlines"}`, http.StatusUnprocessableEntity, "title: must be a single line of text"},
+		{"POST", "/notes", `{"title": 42}`, http.StatusUnprocessableEntity, "title: must be a string"},
+		{"POST", "/notes", `{"title": `, http.StatusBadRequest, ""},
+		{"POST", "/notes", ``, http.StatusBadRequest, ""},
+		{"PUT", "/notes/1", `{"body": "no title"}`, http.StatusUnprocessableEntity, "title: is required"},
+		{"PATCH", "/notes/1", `{"title": ""}`, http.StatusUnprocessableEntity, "title: is required"},
+		{"GET", "/notes?limit=x&sort=body&created_to=soon", "", http.StatusBadRequest,
+			"limit: must be a number; created_to: must be a RFC 3339 time or a YYYY-MM-DD date; sort: must be created_at, updated_at or title"},
+		{"GET", "/notes/search?limit=0", "", http.StatusBadRequest, "limit: must be between 1 and 100; q: is required"},
+		{"GET", "/notes/search?q=*", "", http.StatusBadRequest, "q: has no word to search for"},
+		{"POST", "/notes", `{"title": "a", "tags": "go"}`, http.StatusUnprocessableEntity, "tags: must be an array"},
+		{"POST", "/notes", `{"title": "a", "tags": ["c++"]}`, http.StatusUnprocessableEntity, `tags: "c++" must be at most 32 letters, digits or dashes`},
+		{"GET", "/notes?tag=go&tag_mode=some", "", http.StatusBadRequest, "tag_mode: must be all or any"},
+	}
+	for _, tt := range tests {
+		w := serve(router, tt.method, tt.target, tt.body)
+		var answer problem.Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil || answer.Status != tt.code || w.Header().Get("Content-Type") != problem.ContentType {
+			t.Errorf("%s %s: the answer is not a problem: %s", tt.method, tt.target, w.Body)
+		}
+		errs := models.ValidationError{Errors: answer.Errors}
+		if w.Code != tt.code || errs.Error() != tt.errors {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.target, w.Code, errs.Error(), tt.code, tt.errors)
+		}
+	}
+
+	// the fields are trimmed, and a patch leaves the missing ones alone
+	w := serve(router, "PATCH", "/notes/1", `{"title": "  Espresso  "}`)
+	var patched struct{ Note models.Note }
+	json.Unmarshal(w.Body.Bytes(), &patched)
+	if w.Code != http.StatusOK || patched.Note.Title != "Espresso" || patched.Note.Body != "Brewing espresso" {
+		t.Errorf("trimmed patch: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTagRoutes(t *testing.T) {
+	router := newTestRouter()
+	for _, body := range []string{
+		`{"title": "Espresso", "tags": ["Coffee", "recipes"]}`,
+		`{"title": "Tea", "tags": ["recipes"]}`,
+		`{"title": "Draft"}`,
+	} {
+		if w := serve(router, "POST", "/notes", body); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	list := func(target string) string {
+		t.Helper()
+		var answer struct{ Notes []models.Note }
+		w := serve(router, "GET", target, "")
+		if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", target, w.Code, w.Body)
+		}
+		var titles []string
+		for _, note := range answer.Notes {
+			titles = append(titles, note.Title)
+		}
+		return strings.Join(titles, ",")
+	}
+	if got := list("/notes?sort=title&tag=coffee&tag=Recipes"); got != "Espresso" {
+		t.Errorf("all tags: got %s", got)
+	}
+	if got := list("/notes?sort=title&tag=coffee&tag=recipes&tag_mode=any"); got != "Espresso,Tea" {
+		t.Errorf("any tag: got %s", got)
+	}
+
+	serve(router, "PATCH", "/notes/1", `{"tags": []}`)
+	w := serve(router, "GET", "/tags", "")
+	var answer struct{ Tags []models.TagCount }
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	if w.Code != http.StatusOK || len(answer.Tags) != 1 || answer.Tags[0] != (models.TagCount{Name: "recipes", Count: 1}) {
+		t.Errorf("tags: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRevisionRoutes(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Grind\nBrew\nDrink"}`)
+
+	serve(router, "PUT", "/notes/1/shares/bob", `{"access": "write"}`)
+	send(router, "bob", "PUT", "/notes/1", `{"title": "Coffee", "body": "Grind\nSteep\nDrink"}`, "If-Match", `"1"`)
+
+	w := serve(router, "GET", "/notes/1/revisions", "")
+	var answer struct{ Revisions []models.Revision }
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	if w.Code != http.StatusOK || len(answer.Revisions) != 2 || answer.Revisions[0].Author != "bob" {
+		t.Fatalf("revisions: got %d %s", w.Code, w.Body)
+	}
+
+	w = serve(router, "GET", "/notes/1/revisions/1/diff", "")
+	want := "--- note 1 revision 1\n+++ note 1 current\n@@ -1,5 +1,5 @@\n Coffee\n \n Grind\n-Brew\n+Steep\n Drink\n"
+	if w.Code != http.StatusOK || w.Body.String() != want || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/x-diff") {
+		t.Errorf("diff: got %d %q", w.Code, w.Body)
+	}
+
+	w = serve(router, "POST", "/notes/1/revisions/1/restore", "")
+	var restored struct{ Note models.Note }
+	json.Unmarshal(w.Body.Bytes(), &restored)
+	if w.Code != http.StatusOK || restored.Note.Body != "Grind\nBrew\nDrink" {
+		t.Errorf("restore: got %d %s", w.Code, w.Body)
+	}
+
+	for _, target := range []string{"/notes/1/revisions/9", "/notes/1/revisions/x/diff", "/notes/2/revisions"} {
+		if w := serve(router, "GET", target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestTrashRoutes(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee"}`)
+	serve(router, "POST", "/notes", `{"title": "Tea"}`)
+
+	tests := []struct {
+		method, target string
+		code           int
+	}{
+		{"DELETE", "/notes/1", http.StatusOK},
+		{"GET", "/notes/1", http.StatusNotFound},
+		{"DELETE", "/notes/1", http.StatusNotFound},
+		{"POST", "/notes/2/restore", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(router, tt.method, tt.target, ""); w.Code != tt.code {
+			t.Errorf("%s %s: got %d %s, want %d", tt.method, tt.target, w.Code, w.Body, tt.code)
+		}
+	}
+
+	w := serve(router, "GET", "/notes/trash", "")
+	var trash struct{ Notes []models.Note }
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	if w.Code != http.StatusOK || len(trash.Notes) != 1 || trash.Notes[0].Title != "Coffee" || trash.Notes[0].DeletedAt == nil {
+		t.Fatalf("trash: got %d %s", w.Code, w.Body)
+	}
+
+	w = serve(router, "POST", "/notes/1/restore", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "deleted_at") {
+		t.Errorf("restore: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, "GET", "/notes/1", ""); w.Code != http.StatusOK {
+		t.Errorf("get the restored note: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	router := newTestRouter()
+	w := serve(router, "POST", "/notes", `{"title": "Coffee"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create: got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	tests := []struct {
+		method, header, value string
+		code                  int
+		etag                  string
+	}{
+		{"GET", "", "", http.StatusOK, `"1"`},
+		{"GET", "If-None-Match", `"1"`, http.StatusNotModified, `"1"`},
+		{"GET", "If-None-Match", `W/"1"`, http.StatusNotModified, `"1"`},
+		{"GET", "If-None-Match", `"7", "2"`, http.StatusOK, `"1"`},
+		{"PATCH", "", "", http.StatusPreconditionRequired, ""},
+		{"PATCH", "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"PATCH", "If-Match", `W/"1"`, http.StatusPreconditionFailed, ""},
+		{"PATCH", "If-Match", `"7", "1"`, http.StatusOK, `"2"`},
+		{"PUT", "If-Match", `"2"`, http.StatusOK, `"3"`},
+		{"GET", "If-None-Match", `"1"`, http.StatusOK, `"3"`},
+		{"DELETE", "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"DELETE", "", "", http.StatusPreconditionRequired, ""},
+		{"DELETE", "If-Match", `"3"`, http.StatusOK, ""},
+		{"PATCH", "If-Match", `"4"`, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serveIf(router, tt.method, "/notes/1", `{"title": "Tea"}`, tt.header, tt.value)
+		if w.Code != tt.code || w.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s %s: %s: got %d %q %s, want %d %q", tt.method, tt.header, tt.value, w.Code, w.Header().Get("ETag"), w.Body, tt.code, tt.etag)
+		}
+	}
+}
+
+func TestConditionalRestore(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee"}`)
+	serve(router, "PATCH", "/notes/1", `{"title": "Tea"}`)
+
+	tests := []struct {
+		target, header, value string
+		code                  int
+		etag                  string
+	}{
+		{"/notes/1/revisions/1/restore", "", "", http.StatusPreconditionRequired, ""},
+		{"/notes/1/revisions/1/restore", "If-Match", `"1"`, http.StatusPreconditionFailed, ""},
+		{"/notes/1/revisions/1/restore", "If-Match", `"2"`, http.StatusOK, `"3"`},
+		{"/notes/1/revisions/2/restore", "If-Match", `"2"`, http.StatusPreconditionFailed, ""},
+		{"/notes/1/revisions/9/restore", "If-Match", `"3"`, http.StatusNotFound, ""},
+		{"/notes/2/revisions/1/restore", "If-Match", `"1"`, http.StatusNotFound, ""},
+		{"/notes/1/revisions/2/restore", "If-Match", "*", http.StatusOK, `"4"`},
+	}
+	for _, tt := range tests {
+		w := serveIf(router, "POST", tt.target, "", tt.header, tt.value)
+		if w.Code != tt.code || w.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s %s: %s: got %d %q %s, want %d %q", tt.target, tt.header, tt.value, w.Code, w.Header().Get("ETag"), w.Body, tt.code, tt.etag)
+		}
+	}
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Grind"}`)
+
+	// two clients read the note, then both save their edit
+	etag := serve(router, "GET", "/notes/1", "").Header().Get("ETag")
+	first := serveIf(router, "PATCH", "/notes/1", `{"body": "Grind, brew"}`, "If-Match", etag)
+	second := serveIf(router, "PATCH", "/notes/1", `{"body": "Grind, steep"}`, "If-Match", etag)
+	if first.Code != http.StatusOK || second.Code != http.StatusPreconditionFailed {
+		t.Fatalf("got %d then %d %s", first.Code, second.Code, second.Body)
+	}
+	var p problem.Problem
+	json.Unmarshal(second.Body.Bytes(), &p)
+	if p.Detail != "the note was changed since this version" {
+		t.Errorf("mismatch: got %s", second.Body)
+	}
+
+	// the second client reads the note again before editing it
+	w := serve(router, "GET", "/notes/1", "")
+	var current struct{ Note models.Note }
+	json.Unmarshal(w.Body.Bytes(), &current)
+	if current.Note.Body != "Grind, brew" {
+		t.Errorf("the second edit overwrote the first: got %s", w.Body)
+	}
+	w = serveIf(router, "PATCH", "/notes/1", `{"body": "Grind, brew, steep"}`, "If-Match", w.Header().Get("ETag"))
+	if w.Code != http.StatusOK {
+		t.Errorf("edit after reading again: got %d %s", w.Code, w.Body)
+	}
+}
+
+// zipOf returns a zip of the files, given as name and content pairs
+func zipOf(t *testing.T, files ...string) string {
+	t.Helper()
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		w, err := archive.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(files[i+1]))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestArchiveRoutes(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Grind\nBrew", "tags": ["drinks"]}`)
+	serve(router, "POST", "/notes", `{"title": "Tea: green / black"}`)
+	serve(router, "POST", "/notes", `{"title": "Draft"}`)
+	serve(router, "DELETE", "/notes/3", "")
+
+	w := serve(router, "GET", "/notes/export", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: got %d %s", w.Code, w.Body)
+	}
+	export := w.Body.String()
+	archive, err := zip.NewReader(strings.NewReader(export), int64(len(export)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "0001-coffee.md,0002-tea-green-black.md" {
+		t.Errorf("exported files: got %s", got)
+	}
+
+	// importing the export again, into another repository
+	other := newTestRouter()
+	importZip := func(target, body string) *httptest.ResponseRecorder {
+		return serveIf(other, "POST", target, body, "Content-Type", "application/zip")
+	}
+	if w := importZip("/notes/import?dry_run=true", export); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"dry_run":true`) {
+		t.Errorf("dry run: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(other, "GET", "/notes", ""); !strings.Contains(w.Body.String(), `"total":0`) {
+		t.Errorf("the dry run imported notes: %s", w.Body)
+	}
+	w = importZip("/notes/import", export)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `{"file":"0001-coffee.md","id":1,"title":"Coffee"}`) {
+		t.Errorf("import: got %d %s", w.Code, w.Body)
+	}
+	var answer struct{ Note models.Note }
+	w = serve(other, "GET", "/notes/1", "")
+	json.Unmarshal(w.Body.Bytes(), &answer)
+	if answer.Note.Body != "Grind\nBrew" || strings.Join(answer.Note.Tags, ",") != "drinks" {
+		t.Errorf("imported note: got %s", w.Body)
+	}
+
+	// a file in error fails the whole import, and every error is reported
+	w = importZip("/notes/import", zipOf(t,
+		"good.md", "---\ntitle: Good\n---\n",
+		"notes/untitled.md", "---\ntags: [a]\n---\nNo title",
+		"plain.md", "# Plain",
+		"readme.txt", "skipped",
+	))
+	var p problem.Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	errs := models.ValidationError{Errors: p.Errors}
+	want := "notes/untitled.md: title: is required; plain.md: the file must start with a YAML front matter between --- lines"
+	if w.Code != http.StatusUnprocessableEntity || errs.Error() != want {
+		t.Errorf("errors: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(other, "GET", "/notes", ""); !strings.Contains(w.Body.String(), `"total":2`) {
+		t.Errorf("the failed import imported notes: %s", w.Body)
+	}
+
+	// the files too large to hold a valid note are refused without being
+	// read, and so are the archives too large once uncompressed
+	large := "---\ntitle: Large\n---\n" + strings.Repeat("a", maxMarkdownSize)
+	w = importZip("/notes/import", zipOf(t, "good.md", "---\ntitle: Good\n---\n", "large.md", large))
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if errs := (models.ValidationError{Errors: p.Errors}); w.Code != http.StatusUnprocessableEntity || errs.Error() != "large.md: must be at most 398 KiB once uncompressed" {
+		t.Errorf("large file: got %d %s", w.Code, w.Body)
+	}
+	var files []string
+	for i := 0; i <= maxUncompressedSize/maxMarkdownSize; i++ {
+		files = append(files, fmt.Sprintf("%d.md", i), large[:maxMarkdownSize])
+	}
+	w = importZip("/notes/import", zipOf(t, files...))
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if errs := (models.ValidationError{Errors: p.Errors}); w.Code != http.StatusUnprocessableEntity || errs.Error() != "files: must be at most 64 MiB once uncompressed" {
+		t.Errorf("large archive: got %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		body   string
+		code   int
+		errors string
+	}{
+		{`[{"title": "Milk", "tags": ["dairy"], "created_at": "2024-09-01T12:00:00Z"}]`, http.StatusCreated, ""},
+		{`[{"title": ""}, {"title": 42}, 7, {"title": "Late", "created_at": "soon"}]`, http.StatusUnprocessableEntity,
+			"notes[0]: title: is required; notes[1]: title: must be a string; notes[2]: must be a JSON object; notes[3]: created_at and updated_at must be RFC 3339 times"},
+		{`[]`, http.StatusUnprocessableEntity, ""},
+		{`{"title": "Milk"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := serve(other, "POST", "/notes/import", tt.body)
+		var p problem.Problem
+		json.Unmarshal(w.Body.Bytes(), &p)
+		if errs := (models.ValidationError{Errors: p.Errors}); w.Code != tt.code || errs.Error() != tt.errors {
+			t.Errorf("import %s: got %d %s", tt.body, w.Code, w.Body)
+		}
+	}
+}
+
+// brokenRepository fails like a database that lost its tables
+type brokenRepository struct {
+	*models.MemoryNoteRepository
+}
+
+func (brokenRepository) Get(ctx context.Context, id int) (*models.Note, error) {
+	return nil, errors.New("no such table: notes")
+}
+
+func TestNoteErrorsAreProblems(t *testing.T) {
+	router := newTestRouterWith(brokenRepository{models.NewMemoryNoteRepository()})
+
+	w := serve(router, "GET", "/notes/1", "")
+	var p problem.Problem
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != http.StatusInternalServerError || p.TraceID == "" || strings.Contains(w.Body.String(), "no such table") {
+		t.Errorf("internal error: got %d %s", w.Code, w.Body)
+	}
+
+	w = serve(router, "DELETE", "/notes/7", "")
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != http.StatusNotFound || p.Detail != "note not found" || p.Instance != "/notes/7" {
+		t.Errorf("missing note: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	router := newTestRouter()
+	w := serveAs(router, "", "GET", "/notes", "")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Errorf("no token: got %d %s", w.Code, w.Body)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.RegisteredClaims{
+		Issuer: "jwt-go-example", Subject: "alice", Audience: jwt.ClaimStrings{"jwt-go-example"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(otherKey)
+	for _, authorization := range []string{"Bearer " + forged, "Basic YWxpY2U6c2VjcmV0", "Bearer"} {
+		w := serveIf(router, "GET", "/notes", "", "Authorization", authorization)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d %s", authorization, w.Code, w.Body)
+		}
+	}
+
+	// the notes belong to their creator, and the others see none of them
+	w = serve(router, "POST", "/notes", `{"title": "Coffee", "tags": ["drinks"]}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"owner":"alice"`) {
+		t.Fatalf("create: got %d %s", w.Code, w.Body)
+	}
+	for _, target := range []string{"/notes", "/tags", "/notes/search?q=coffee"} {
+		if w := serveAs(router, "bob", "GET", target, ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Coffee") || strings.Contains(w.Body.String(), "drinks") {
+			t.Errorf("%s as bob: got %d %s", target, w.Code, w.Body)
+		}
+	}
+	for _, method := range []string{"GET", "DELETE"} {
+		if w := serveAs(router, "bob", method, "/notes/1", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s a note of alice as bob: got %d %s", method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRevokedTokens(t *testing.T) {
+	revoked := tokenFor("alice")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"active": %t}`, r.PostFormValue("token") != revoked)
+	}))
+	defer server.Close()
+	router := gin.New()
+	router.GET("/notes", RequireUser(&auth.Verifier{
+		Issuer:       "jwt-go-example",
+		Audience:     "jwt-go-example",
+		Keyfunc:      func(*jwt.Token) (any, error) { return testKey.Public(), nil },
+		Introspector: auth.NewIntrospector(server.URL, "notes", "secret"),
+	}), NewNoteController(models.NewMemoryNoteRepository()).GetAllNotes)
+
+	if w := serveIf(router, "GET", "/notes", "", "Authorization", "Bearer "+revoked); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "revoked") {
+		t.Errorf("revoked token: got %d %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob", "GET", "/notes", ""); w.Code != http.StatusOK {
+		t.Errorf("active token: got %d %s", w.Code, w.Body)
+	}
+	server.Close()
+	if w := serveAs(router, "carol", "GET", "/notes", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("introspection down: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee"}`)
+	tests := []struct {
+		scope, method, target string
+		code                  int
+	}{
+		{"notes:read", "GET", "/notes/1", http.StatusOK},
+		{"notes:read", "GET", "/notes/export", http.StatusOK},
+		{"notes:read", "PATCH", "/notes/1", http.StatusForbidden},
+		{"notes:read", "POST", "/notes", http.StatusForbidden},
+		{"notes:write", "GET", "/notes", http.StatusForbidden},
+		{"notes:write", "PATCH", "/notes/1", http.StatusOK},
+		{"profile:read", "GET", "/notes", http.StatusForbidden},
+		{"", "GET", "/notes", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"title": "Tea"}`))
+		r.Header.Set("Authorization", "Bearer "+tokenWithScope("alice", tt.scope))
+		r.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s with %q: got %d %s", tt.method, tt.target, tt.scope, w.Code, w.Body)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); w.Code == http.StatusForbidden && !strings.Contains(challenge, `error="insufficient_scope"`) {
+			t.Errorf("%s %s with %q: WWW-Authenticate %q", tt.method, tt.target, tt.scope, challenge)
+		}
+	}
+}
+
+func TestSharingRoutes(t *testing.T) {
+	router := newTestRouter()
+	serve(router, "POST", "/notes", `{"title": "Coffee", "body": "Grind"}`)
+
+	w := serve(router, "PUT", "/notes/1/shares/bob", `{"access": "read"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"access":"read"`) {
+		t.Fatalf("share: got %d %s", w.Code, w.Body)
+	}
+	for _, tt := range []struct {
+		target, body string
+		want         int
+	}{
+		{"/notes/1/shares/carol", `{"access": "admin"}`, http.StatusUnprocessableEntity},
+		{"/notes/1/shares/alice", `{"access": "read"}`, http.StatusUnprocessableEntity},
+		{"/notes/2/shares/bob", `{"access": "read"}`, http.StatusNotFound},
+	} {
+		if w := serve(router, "PUT", tt.target, tt.body); w.Code != tt.want {
+			t.Errorf("PUT %s %s: got %d %s", tt.target, tt.body, w.Code, w.Body)
+		}
+	}
+	if w := serveAs(router, "bob", "GET", "/notes/shared", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Coffee") {
+		t.Errorf("shared notes: got %d %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob", "GET", "/notes/1", ""); w.Code != http.StatusOK {
+		t.Errorf("get a shared note: got %d %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob", "PATCH", "/notes/1", `{"body": "Brew"}`); w.Code != http.StatusForbidden {
+		t.Errorf("patch a note shared read only: got %d %s", w.Code, w.Body)
+	}
+	serve(router, "PUT", "/notes/1/shares/bob", `{"access": "write"}`)
+	if w := serveAs(router, "bob", "PATCH", "/notes/1", `{"body": "Brew"}`); w.Code != http.StatusOK {
+		t.Errorf("patch a note shared to write: got %d %s", w.Code, w.Body)
+	}
+	for _, tt := range []struct{ method, target string }{
+		{"DELETE", "/notes/1"},
+		{"GET", "/notes/1/shares"},
+		{"POST", "/notes/1/links"},
+	} {
+		if w := serveAs(router, "bob", tt.method, tt.target, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s as bob: got %d %s", tt.method, tt.target, w.Code, w.Body)
+		}
+	}
+	if w := serve(router, "DELETE", "/notes/1/shares/bob", ""); w.Code != http.StatusOK {
+		t.Errorf("unshare: got %d %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "bob", "GET", "/notes/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get an unshared note: got %d %s", w.Code, w.Body)
+	}
+
+	// a share link reads the note without a token, until it is deleted
+	w = serve(router, "POST", "/notes/1/links", `{"expires_in": 3600}`)
+	var created struct {
+		Link models.ShareLink
+		URL  string
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if w.Code != http.StatusCreated || created.URL != "/shared/"+created.Link.Token || time.Until(created.Link.ExpiresAt) > time.Hour {
+		t.Fatalf("create link: got %d %s", w.Code, w.Body)
+	}
+	if w := serveAs(router, "", "GET", created.URL, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Brew") {
+		t.Errorf("shared link: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, "POST", "/notes/1/links", ""); w.Code != http.StatusCreated {
+		t.Errorf("create a link with the default lifetime: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, "POST", "/notes/1/links", `{"expires_in": 8000000}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("create a link living too long: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, "GET", "/notes/1/links", ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Link.Token) {
+		t.Errorf("links: got %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, "DELETE", "/notes/1/links/1", ""); w.Code != http.StatusOK {
+		t.Errorf("delete link: got %d %s", w.Code, w.Body)
+	}
+	for _, target := range []string{created.URL, "/shared/nope"} {
+		if w := serveAs(router, "", "GET", target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d %s", target, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+// parseListParams reads the paging, sorting and filtering query parameters.
+// The tag parameter may be repeated.
+// The problems are returned as a *models.ValidationError.
+func parseListParams(c *gin.Context) (models.ListParams, error) {
+	var params models.ListParams
+	var errs models.ValidationError
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if params.Limit, err = strconv.Atoi(s); err != nil {
+			errs.Add("limit", "must be a number")
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if params.Offset, err = strconv.Atoi(s); err != nil {
+			errs.Add("offset", "must be a number")
+		}
+	}
+	params.Cursor = c.Query("cursor")
+	params.Sort = c.Query("sort")
+	params.Order = c.Query("order")
+	params.Tags = c.QueryArray("tag")
+	params.TagMode = c.Query("tag_mode")
+	if s := c.Query("created_from"); s != "" {
+		if params.CreatedFrom, err = parseDate(s, false); err != nil {
+			errs.Add("created_from", err.Error())
+		}
+	}
+	if s := c.Query("created_to"); s != "" {
+		if params.CreatedTo, err = parseDate(s, true); err != nil {
+			errs.Add("created_to", err.Error())
+		}
+	}
+	var invalid *models.ValidationError
+	if errors.As(params.Validate(), &invalid) {
+		errs.Errors = append(errs.Errors, invalid.Errors...)
+	}
+	return params, errs.Err()
+}
+
+// parseDate reads a RFC 3339 time or a YYYY-MM-DD date. The end of a range
+// includes the whole day of a date, or the exact time given.
+func parseDate(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		if end {
+			t = t.Add(time.Nanosecond)
+		}
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("must be a RFC 3339 time or a YYYY-MM-DD date")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// paginationLinks returns the Link header of a page: "next" follows the
+// cursor, while "first" and "prev" are given to the clients paging by offset
+func paginationLinks(u *url.URL, params models.ListParams, page *models.NotePage) string {
+	link := func(rel string, set func(q url.Values)) string {
+		q := u.Query()
+		set(q)
+		next := *u
+		next.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, next.RequestURI(), rel)
+	}
+	var links []string
+	if page.NextCursor != "" {
+		links = append(links, link("next", func(q url.Values) {
+			q.Del("offset")
+			q.Set("cursor", page.NextCursor)
+		}))
+	}
+	if params.Cursor == "" && params.Offset > 0 {
+		links = append(links, link("first", func(q url.Values) { q.Del("offset") }))
+		prev := params.Offset - params.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", func(q url.Values) { q.Set("offset", strconv.Itoa(prev)) }))
+	}
+	return strings.Join(links, ", ")
+}
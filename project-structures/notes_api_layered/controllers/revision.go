@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+func (nc *NoteController) GetRevisions(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	revisions, err := nc.Notes.Revisions(c.Request.Context(), id)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "All Revisions",
+			"revisions": revisions,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+func (nc *NoteController) GetSingleRevision(c *gin.Context) {
+	id, number, ok := revisionNumber(c)
+	if !ok {
+		return
+	}
+	rev, err := nc.Notes.Revision(c.Request.Context(), id, number)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Single Revision",
+			"revision": rev,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// DiffRevision answers the unified diff turning the revision into the
+// current version of the note, as text that patch and the diff viewers read
+func (nc *NoteController) DiffRevision(c *gin.Context) {
+	id, number, ok := revisionNumber(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	rev, err := nc.Notes.Revision(ctx, id, number)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	note, err := nc.Notes.Get(ctx, id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(rev.Diff(note)))
+}
+func (nc *NoteController) RestoreRevision(c *gin.Context) {
+	id, number, ok := revisionNumber(c)
+	if !ok {
+		return
+	}
+	version, ok := nc.ifMatch(c, id)
+	if !ok {
+		return
+	}
+	note, err := nc.Notes.Restore(c.Request.Context(), id, number, version)
+	if err == nil {
+		setETag(c, note)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note restored successfully",
+			"note":    note,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// revisionNumber reads the ids of the note and of the revision from the
+// path, answering 404 for the ones that are not numbers
+func revisionNumber(c *gin.Context) (int, int, bool) {
+	id, ok := noteId(c)
+	if !ok {
+		return 0, 0, false
+	}
+	number, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		writeError(c, models.ErrRevisionNotFound)
+		return 0, 0, false
+	}
+	return id, number, true
+}
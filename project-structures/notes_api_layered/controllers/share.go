@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+// GetShares lists the users a note is shared with. Only its owner sees them.
+func (nc *NoteController) GetShares(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	shares, err := nc.Notes.Shares(c.Request.Context(), id)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All Shares",
+			"shares":  shares,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// ShareNote gives the user of the path read or write access to a note, or
+// changes the access they have
+func (nc *NoteController) ShareNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	params := models.ShareParams{Username: c.Param("username")}
+	if !bindNote(c, &params) {
+		return
+	}
+	share, err := nc.Notes.Share(c.Request.Context(), id, params)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Note shared successfully",
+			"share":   share,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// UnshareNote takes the access to a note away from the user of the path
+func (nc *NoteController) UnshareNote(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	err := nc.Notes.Unshare(c.Request.Context(), id, c.Param("username"))
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Share deleted successfully",
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// GetLinks lists the share links of a note that have not expired, without
+// their tokens
+func (nc *NoteController) GetLinks(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	links, err := nc.Notes.Links(c.Request.Context(), id)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All Share Links",
+			"links":   links,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// CreateLink creates a share link to read a note without a token. The body
+// may give its lifetime in seconds. The token of the link is only answered
+// here, in the link and in its URL.
+func (nc *NoteController) CreateLink(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	var params models.LinkParams
+	if c.Request.ContentLength == 0 {
+		params.Normalize()
+	} else if !bindNote(c, &params) {
+		return
+	}
+	link, err := nc.Notes.CreateLink(c.Request.Context(), id, params.ExpiresAt(time.Now()))
+	if err == nil {
+		url := "/shared/" + link.Token
+		c.Header("Location", url)
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Share link created successfully",
+			"link":    link,
+			"url":     url,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// DeleteLink revokes a share link of a note
+func (nc *NoteController) DeleteLink(c *gin.Context) {
+	id, ok := noteId(c)
+	if !ok {
+		return
+	}
+	linkId, err := strconv.Atoi(c.Param("link_id"))
+	if err != nil {
+		writeError(c, models.ErrLinkNotFound)
+		return
+	}
+	err = nc.Notes.DeleteLink(c.Request.Context(), id, linkId)
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Share link deleted successfully",
+		})
+	} else {
+		writeError(c, err)
+	}
+}
+
+// GetSharedNote answers the note of a share link to anyone with its token.
+// It is served without authentication.
+func (nc *NoteController) GetSharedNote(c *gin.Context) {
+	note, err := nc.Notes.SharedNote(c.Request.Context(), c.Param("token"))
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Shared Note",
+			"note":    note,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAllTags lists the tags on at least one note, with their number of notes
+func (nc *NoteController) GetAllTags(c *gin.Context) {
+	tags, err := nc.Notes.Tags(c.Request.Context())
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All Tags",
+			"tags":    tags,
+		})
+	} else {
+		writeError(c, err)
+	}
+}
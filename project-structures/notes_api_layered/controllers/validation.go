@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/favtuts/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/models"
+)
+
+// input is a request body that can clean and check itself
+type input interface {
+	Normalize()
+	Validate() error
+}
+
+// bindNote decodes the JSON body into params, normalizes it and validates
+// it. On failure it answers a problem, 400 for a body that cannot be decoded
+// and 422 for invalid fields, and returns false.
+func bindNote(c *gin.Context, params input) bool {
+	if err := c.ShouldBindJSON(params); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			var errs models.ValidationError
+			errs.Add(typeErr.Field, "must be "+jsonType(typeErr.Type.Kind()))
+			writeError(c, &errs)
+		case errors.Is(err, io.EOF):
+			writeError(c, problem.New(problem.ErrBadRequest, "the request body is empty, it must be a JSON object"))
+		default:
+			writeError(c, problem.New(problem.ErrBadRequest, "the request body is not a valid JSON object: "+err.Error()))
+		}
+		return false
+	}
+	params.Normalize()
+	if err := params.Validate(); err != nil {
+		writeError(c, err)
+		return false
+	}
+	return true
+}
+
+// jsonType names a Go kind as a JSON type, with its article
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Bool:
+		return "a boolean"
+	}
+	return "a number"
+}
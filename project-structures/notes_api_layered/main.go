@@ -1,28 +1,144 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/favtuts/problem"
 	"github.com/gin-gonic/gin"
+	"github.com/username/notes_api_layered/auth"
 	"github.com/username/notes_api_layered/config"
 	"github.com/username/notes_api_layered/controllers"
 	"github.com/username/notes_api_layered/migrations"
+	"github.com/username/notes_api_layered/models"
 )
 
+// newRepository returns the repository of the configured backend
+func newRepository(cfg config.Config, db *sql.DB) models.NoteRepository {
+	switch cfg.Driver {
+	case "postgres":
+		return models.NewPostgresNoteRepository(db)
+	case "memory":
+		return models.NewMemoryNoteRepository()
+	}
+	return models.NewSQLiteNoteRepository(db)
+}
+
 func main() {
-	_, err := config.InitializeDB()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := config.InitializeDB(cfg)
 	if err != nil {
-		log.Println("Driver creation failed", err.Error())
+		log.Fatal("Driver creation failed: ", err)
 	} else {
-		// Run all migrations
-		migrations.Run()
+		// "notes_api_layered migrate ..." manages the schema instead of serving
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			if err := migrations.Command(db, cfg.Driver, os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		// Run all migrations, refusing to start on a schema newer than the binary.
+		// The notes kept in memory need none.
+		if db != nil {
+			if err := migrations.Run(db, cfg.Driver); err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			if err := migrations.ClaimLegacyNotes(db, cfg.LegacyOwner); err != nil {
+				log.Fatal(err)
+			}
+		}
 
 		router := gin.Default()
 
-		var noteController controllers.NoteController
-		router.GET("/notes", noteController.GetAllNotes)
-		router.POST("/notes", noteController.CreateNewNote)
-		router.GET("/notes/:note_id", noteController.GetSingleNote)
-		router.Run(":8000")
+		notes := newRepository(cfg, db)
+		noteController := controllers.NewNoteController(notes)
+		// The share links are read without a token, everything else acts for
+		// the user of the bearer token
+		router.GET("/shared/:token", noteController.GetSharedNote)
+		verifier := &auth.Verifier{
+			Issuer:    cfg.JWTIssuer,
+			Audience:  cfg.JWTAudience,
+			ClockSkew: 30 * time.Second,
+			Keyfunc:   auth.NewJWKS(cfg.JWKSURL).Keyfunc,
+		}
+		if cfg.ClientID != "" {
+			verifier.Introspector = auth.NewIntrospector(cfg.IntrospectionURL, cfg.ClientID, cfg.ClientSecret)
+		} else {
+			log.Println("NOTES_CLIENT_ID is not set: the revoked tokens are accepted until they expire")
+		}
+		user := router.Group("/", controllers.RequireUser(verifier))
+		user.GET("/notes", noteController.GetAllNotes)
+		user.POST("/notes", noteController.CreateNewNote)
+		user.GET("/notes/search", noteController.SearchNotes)
+		user.GET("/notes/trash", noteController.GetTrash)
+		user.GET("/notes/shared", noteController.GetSharedNotes)
+		user.GET("/notes/export", noteController.ExportNotes)
+		user.POST("/notes/import", noteController.ImportNotes)
+		user.GET("/notes/:note_id", noteController.GetSingleNote)
+		user.PUT("/notes/:note_id", noteController.UpdateNote)
+		user.PATCH("/notes/:note_id", noteController.PatchNote)
+		user.DELETE("/notes/:note_id", noteController.DeleteNote)
+		user.POST("/notes/:note_id/restore", noteController.RestoreNote)
+		user.GET("/notes/:note_id/revisions", noteController.GetRevisions)
+		user.GET("/notes/:note_id/revisions/:revision", noteController.GetSingleRevision)
+		user.GET("/notes/:note_id/revisions/:revision/diff", noteController.DiffRevision)
+		user.POST("/notes/:note_id/revisions/:revision/restore", noteController.RestoreRevision)
+		user.GET("/notes/:note_id/shares", noteController.GetShares)
+		user.PUT("/notes/:note_id/shares/:username", noteController.ShareNote)
+		user.DELETE("/notes/:note_id/shares/:username", noteController.UnshareNote)
+		user.GET("/notes/:note_id/links", noteController.GetLinks)
+		user.POST("/notes/:note_id/links", noteController.CreateLink)
+		user.DELETE("/notes/:note_id/links/:link_id", noteController.DeleteLink)
+		user.GET("/tags", noteController.GetAllTags)
+		router.NoRoute(func(c *gin.Context) {
+			problem.Error(c.Writer, c.Request, http.StatusNotFound, "", "no route matches "+c.Request.URL.Path)
+		})
+
+		// Stop on Ctrl-C or SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var purging sync.WaitGroup
+		if cfg.TrashRetention > 0 {
+			purger := models.NewPurger(notes, cfg.TrashRetention, cfg.PurgeInterval)
+			purging.Add(1)
+			go func() {
+				defer purging.Done()
+				purger.Run(ctx)
+			}()
+		}
+
+		// The middleware gives every request the trace id of its problems
+		server := &http.Server{Addr: ":8000", Handler: problem.Middleware(router)}
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+
+		<-ctx.Done()
+		log.Println("Shutting down")
+		// let the requests in progress finish, and wait for the purger to stop
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Shutdown:", err)
+		}
+		purging.Wait()
+		if db != nil {
+			db.Close()
+		}
 	}
 }
@@ -0,0 +1,125 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// SourceDir is where "migrate create" writes the new migrations, relative to
+// the root of the project. They are embedded in the binary when it is built.
+const SourceDir = "migrations"
+
+const usage = `usage:
+  migrate up           apply every pending migration
+  migrate down N       revert the last N migrations
+  migrate status       list the migrations and whether they are applied
+  migrate claim USER   give the notes written before they had owners to USER
+  migrate create NAME  add an empty migration for every database to ` + SourceDir + "/sql"
+
+// Command runs the "migrate" subcommand with its arguments, on db of the
+// given driver.
+func Command(db *sql.DB, driver string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		return create(SourceDir, args[1])
+	}
+
+	if db == nil {
+		return fmt.Errorf("the %q database has no migrations", driver)
+	}
+	m, err := New(db, driver)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		n, err := m.Up(ctx)
+		fmt.Printf("%d migrations applied\n", n)
+		return err
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errors.New("down needs the number of migrations to revert")
+		}
+		return m.Down(ctx, n)
+	case args[0] == "claim" && len(args) == 2:
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if version < 7 {
+			return fmt.Errorf("claim needs migration 0007_add_notes_owner, the schema is at version %d", version)
+		}
+		n, err := ClaimOwnerless(ctx, db, args[1])
+		fmt.Printf("%d notes given to %s\n", n, args[1])
+		return err
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, applied)
+		}
+		version, err := m.Version(ctx)
+		if err == nil && version > m.Latest() {
+			fmt.Printf("the schema is at version %d, which this binary does not know\n", version)
+		}
+		return err
+	}
+	return errors.New(usage)
+}
+
+var migrationName = regexp.MustCompile(`^\w+$`)
+
+// create writes the empty up and down files of a new migration for every
+// database to the directories under dir, numbered after the newest one
+func create(dir, name string) error {
+	if !migrationName.MatchString(name) {
+		return errors.New("the name may only contain letters, digits and underscores")
+	}
+	version := 1
+	for _, d := range dialects {
+		migrations, err := Load(os.DirFS(dir), d.dir)
+		if err != nil {
+			return fmt.Errorf("%w (create must run from the root of the project)", err)
+		}
+		if n := len(migrations); n > 0 && migrations[n-1].Version >= version {
+			version = migrations[n-1].Version + 1
+		}
+	}
+	drivers := make([]string, 0, len(dialects))
+	for driver := range dialects {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	for _, driver := range drivers {
+		d := dialects[driver]
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(dir, d.dir, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s migration %04d_%s for %s\n", direction, version, name, driver)
+			if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+				return err
+			}
+			fmt.Println("created", file)
+		}
+	}
+	return nil
+}
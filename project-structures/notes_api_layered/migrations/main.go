@@ -1,28 +1,310 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
-
-	"github.com/username/notes_api_layered/config"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-func Run() {
-	// Migrate notes
-	migrate(config.DB, Notes)
-	// Other migrations can be added here.
+// The migrations are numbered pairs of files in the directory of each
+// database: NNNN_name.up.sql applies a change and NNNN_name.down.sql
+// reverts it. Both directories get the same versions.
+//
+//go:embed sql/sqlite/*.sql sql/postgres/*.sql
+var files embed.FS
+
+// dialect holds what differs between the databases
+type dialect struct {
+	// dir holds the migrations of the database
+	dir string
+	// begin starts a transaction holding a lock that serializes the migrations
+	begin []string
+}
+
+// The dialects, by the names used in the configuration
+var dialects = map[string]dialect{
+	"sqlite": {
+		dir: "sql/sqlite",
+		// BEGIN IMMEDIATE takes the write lock of the database at once
+		begin: []string{"BEGIN IMMEDIATE"},
+	},
+	"postgres": {
+		dir: "sql/postgres",
+		// the advisory lock is released at the end of the transaction
+		begin: []string{"BEGIN", "SELECT pg_advisory_xact_lock(7340001)"},
+	},
+}
+
+var ErrSchemaAhead = errors.New("the database schema is newer than this binary, which would not know how to use it")
+
+// Migration is a numbered change of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
 }
 
-func migrate(dbDriver *sql.DB, query string) {
-	statement, err := dbDriver.Prepare(query)
-	if err == nil {
-		_, creationError := statement.Exec()
-		if creationError == nil {
-			log.Println("Table created successfully")
+// MigrationStatus tells whether a migration has been applied, and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in the directory dir of fsys, sorted by version.
+// Every migration must have both an up and a down file.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := fileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: the name must look like 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(content)
 		} else {
-			log.Println(creationError.Error())
+			migration.Down = string(content)
 		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+const createSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  applied_at TIMESTAMP NOT NULL
+)
+`
+
+// Migrator applies and reverts the migrations on a database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+	// mu serializes the migrations run by this process, the database lock
+	// taken by each transaction serializes them with the other processes
+	mu sync.Mutex
+}
+
+// NewMigrator returns a Migrator for the migrations of fsys for the
+// database named driver, "sqlite" or "postgres".
+func NewMigrator(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for the %q database", driver)
+	}
+	migrations, err := Load(fsys, d.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createSchemaMigrations); err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// New returns a Migrator for the migrations embedded in the binary.
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	return NewMigrator(db, driver, files)
+}
+
+// Latest is the version of the newest migration known to the binary
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version is the version of the newest migration applied to the database
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version int
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// Check returns ErrSchemaAhead if the database has migrations the binary does not know.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: the schema is at version %d, the binary at %d", ErrSchemaAhead, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies the migrations that have not been applied yet, in order, and
+// returns how many it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.Check(ctx); err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, migration := range m.migrations {
+		ran, err := m.run(ctx, migration, true)
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the last n applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if err := m.Check(ctx); err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0 && n > 0; i-- {
+		ran, err := m.run(ctx, m.migrations[i], false)
+		if err != nil {
+			return err
+		}
+		if ran {
+			n--
+		}
+	}
+	return nil
+}
+
+// run applies or reverts one migration in its own transaction, unless it
+// has already been. The transaction takes a lock before reading
+// schema_migrations, so that two processes migrating at the same time
+// cannot both run it.
+func (m *Migrator) run(ctx context.Context, migration Migration, up bool) (ran bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	for i, statement := range m.dialect.begin {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			if i > 0 {
+				conn.ExecContext(context.Background(), "ROLLBACK")
+			}
+			return false, err
+		}
+	}
+	defer func() {
+		if err != nil || !ran {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	// go-sqlite3 binds the $1 placeholders of PostgreSQL by position too
+	var applied int
+	err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", migration.Version).Scan(&applied)
+	if err != nil || (applied > 0) == up {
+		return false, err
+	}
+
+	direction, script := "down", migration.Down
+	if up {
+		direction, script = "up", migration.Up
+	}
+	if _, err := conn.ExecContext(ctx, script); err != nil {
+		return false, fmt.Errorf("migration %04d_%s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	if up {
+		_, err = conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			migration.Version, migration.Name, time.Now().UTC())
 	} else {
-		log.Println(err.Error())
+		_, err = conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return false, err
+	}
+	log.Printf("Migration %04d_%s %s", migration.Version, migration.Name, direction)
+	return true, nil
+}
+
+// Status lists the migrations known to the binary, with their applied time.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i].Migration = migration
+		if at, ok := appliedAt[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Run brings the schema of db up to date. It refuses to run against a
+// schema newer than the binary.
+func Run(db *sql.DB, driver string) error {
+	m, err := New(db, driver)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(context.Background())
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		err = fmt.Errorf("%w (build with -tags sqlite_fts5)", err)
 	}
+	return err
 }
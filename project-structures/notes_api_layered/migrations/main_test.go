@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testFS(n int) fstest.MapFS {
+	fsys := fstest.MapFS{
+		"sql/sqlite/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"sql/sqlite/0001_create_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"sql/sqlite/0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER); INSERT INTO b VALUES (1);")},
+		"sql/sqlite/0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"sql/sqlite/0003_broken.up.sql":     {Data: []byte("CREATE TABLE c (id INTEGER); INSERT INTO nope VALUES (1);")},
+		"sql/sqlite/0003_broken.down.sql":   {Data: []byte("DROP TABLE c;")},
+	}
+	if n < 3 {
+		delete(fsys, "sql/sqlite/0003_broken.up.sql")
+		delete(fsys, "sql/sqlite/0003_broken.down.sql")
+	}
+	if n < 2 {
+		delete(fsys, "sql/sqlite/0002_create_b.up.sql")
+		delete(fsys, "sql/sqlite/0002_create_b.down.sql")
+	}
+	return fsys
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: opens a new database
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	m, err := NewMigrator(db, "sqlite", testFS(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 2 {
+		t.Fatalf("up: applied %d, %v", n, err)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("up again: applied %d, %v", n, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil || len(statuses) != 2 || statuses[1].AppliedAt == nil || statuses[1].Name != "create_b" {
+		t.Fatalf("status: got %+v, %v", statuses, err)
+	}
+
+	// a failing migration is rolled back and not recorded
+	broken, err := NewMigrator(db, "sqlite", testFS(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := broken.Up(ctx); err == nil || n != 0 {
+		t.Fatalf("broken up: applied %d, %v", n, err)
+	}
+	if tableExists(t, db, "c") {
+		t.Error("the broken migration was not rolled back")
+	}
+	if version, _ := broken.Version(ctx); version != 2 {
+		t.Errorf("version after the broken migration: %d", version)
+	}
+
+	// an older binary refuses a newer schema
+	db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (3, 'broken', CURRENT_TIMESTAMP)")
+	if _, err := m.Up(ctx); !errors.Is(err, ErrSchemaAhead) {
+		t.Fatalf("up on a newer schema: %v", err)
+	}
+	db.Exec("DELETE FROM schema_migrations WHERE version = 3")
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, db, "b") || !tableExists(t, db, "a") {
+		t.Error("down 1 did not revert only the last migration")
+	}
+	if err := m.Down(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, db, "a") {
+		t.Error("down 5 did not revert every migration")
+	}
+	if version, _ := m.Version(ctx); version != 0 {
+		t.Errorf("version after reverting everything: %d", version)
+	}
+}
+
+func TestClaimLegacyNotes(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	// two notes written before migration 0007_add_notes_owner, and one after
+	_, err = db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, owner VARCHAR(64) NOT NULL DEFAULT '');
+INSERT INTO notes (owner) VALUES (''), (''), ('user2');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := func() map[string]int {
+		t.Helper()
+		rows, err := db.Query("SELECT owner, COUNT(*) FROM notes GROUP BY owner")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		counts := map[string]int{}
+		for rows.Next() {
+			var owner string
+			var n int
+			if err := rows.Scan(&owner, &n); err != nil {
+				t.Fatal(err)
+			}
+			counts[owner] = n
+		}
+		return counts
+	}
+
+	// without a legacy owner, the server refuses to start instead of hiding them
+	if err := ClaimLegacyNotes(db, ""); err == nil || !strings.Contains(err.Error(), "2 notes") {
+		t.Fatalf("without a legacy owner: got %v", err)
+	}
+	if err := ClaimLegacyNotes(db, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := owners(); got["user1"] != 2 || got["user2"] != 1 || got[""] != 0 {
+		t.Errorf("owners after the claim: got %v", got)
+	}
+	if err := ClaimLegacyNotes(db, ""); err != nil {
+		t.Errorf("once claimed: got %v", err)
+	}
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	sqlite, err := Load(files, dialects["sqlite"].dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range sqlite {
+		if m.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", m.Name, m.Version, i+1)
+		}
+	}
+	// every database must get the same migrations
+	postgres, err := Load(files, dialects["postgres"].dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("%d postgres migrations, %d sqlite ones", len(postgres), len(sqlite))
+	}
+	for i := range sqlite {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("postgres migration %04d_%s, sqlite %04d_%s", postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+	if _, err := Load(fstest.MapFS{"sql/sqlite/0001_a.up.sql": {Data: []byte("SELECT 1;")}}, "sql/sqlite"); err == nil {
+		t.Error("a migration without down file was loaded")
+	}
+}
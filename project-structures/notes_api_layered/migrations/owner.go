@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// ClaimOwnerless gives the notes without an owner to owner and returns how
+// many it gave. Those are the notes written before migration
+// 0007_add_notes_owner, which no user can see until they have an owner.
+func ClaimOwnerless(ctx context.Context, db *sql.DB, owner string) (int64, error) {
+	res, err := db.ExecContext(ctx, "UPDATE notes SET owner = $1 WHERE owner = ''", owner)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ClaimLegacyNotes gives the notes without an owner to legacyOwner. Without
+// a legacyOwner, it returns an error if there are such notes, so that the
+// server refuses to start rather than hide them from every user.
+func ClaimLegacyNotes(db *sql.DB, legacyOwner string) error {
+	ctx := context.Background()
+	if legacyOwner != "" {
+		n, err := ClaimOwnerless(ctx, db, legacyOwner)
+		if n > 0 {
+			log.Printf("Gave the %d notes without an owner to %s", n, legacyOwner)
+		}
+		return err
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes WHERE owner = ''").Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d notes were written before they had owners and no user can see them: "+
+			"set NOTES_LEGACY_OWNER to the user who gets them, or run migrate claim USERNAME", n)
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+DROP INDEX notes_search;
+ALTER TABLE notes DROP COLUMN search;
@@ -0,0 +1,6 @@
+-- The full-text index of the notes, the title weighing more (A) than the body (B)
+ALTER TABLE notes ADD COLUMN search tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', body), 'B')
+) STORED;
+
+CREATE INDEX notes_search ON notes USING GIN (search);
@@ -0,0 +1,3 @@
+DROP TRIGGER note_revisions_immutable ON note_revisions;
+DROP FUNCTION note_revisions_immutable;
+DROP TABLE note_revisions;
@@ -0,0 +1,25 @@
+-- Every version of the notes, numbered from 1 for each note. The rows are
+-- never changed, the trigger refuses the updates.
+CREATE TABLE IF NOT EXISTS note_revisions (
+  id SERIAL PRIMARY KEY,
+  note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+  revision INTEGER NOT NULL,
+  title VARCHAR(64) NOT NULL,
+  body TEXT NOT NULL,
+  author VARCHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  UNIQUE (note_id, revision)
+);
+
+CREATE OR REPLACE FUNCTION note_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'note revisions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER note_revisions_immutable BEFORE UPDATE ON note_revisions
+FOR EACH ROW EXECUTE FUNCTION note_revisions_immutable();
+
+-- The notes written before the history existed start it with their current version
+INSERT INTO note_revisions (note_id, revision, title, body, author, created_at)
+SELECT id, 1, title, body, '', updated_at FROM notes;
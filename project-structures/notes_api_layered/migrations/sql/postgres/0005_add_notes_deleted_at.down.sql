@@ -0,0 +1,7 @@
+-- The notes in the trash would come back, they are deleted instead
+DELETE FROM note_tags WHERE note_id IN (SELECT id FROM notes WHERE deleted_at IS NOT NULL);
+DELETE FROM note_revisions WHERE note_id IN (SELECT id FROM notes WHERE deleted_at IS NOT NULL);
+DELETE FROM notes WHERE deleted_at IS NOT NULL;
+DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM note_tags WHERE note_tags.tag_id = tags.id);
+DROP INDEX notes_deleted_at;
+ALTER TABLE notes DROP COLUMN deleted_at;
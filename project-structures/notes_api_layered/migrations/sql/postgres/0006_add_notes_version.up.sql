@@ -0,0 +1,3 @@
+-- The version of a note counts its changes. It is the ETag of the note, and
+-- a change made with an older version is refused.
+ALTER TABLE notes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,31 @@
+-- Every note belongs to the user who created it. The notes written before
+-- belong to nobody until they are given an owner: the server refuses to
+-- start with such notes, unless NOTES_LEGACY_OWNER names the user who gets
+-- them, and "migrate claim USERNAME" gives them to a user.
+ALTER TABLE notes ADD COLUMN owner VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS notes_owner ON notes (owner, created_at);
+
+-- The users a note is shared with, who may read it or also change it
+CREATE TABLE IF NOT EXISTS note_shares (
+  note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+  username VARCHAR(64) NOT NULL,
+  access VARCHAR(5) NOT NULL CHECK (access IN ('read', 'write')),
+  created_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (note_id, username)
+);
+
+-- The primary key finds the users of a note, this index the notes of a user
+CREATE INDEX IF NOT EXISTS note_shares_username ON note_shares (username);
+
+-- The links giving anyone read access to a note until they expire. Only the
+-- SHA-256 of their token is stored.
+CREATE TABLE IF NOT EXISTS share_links (
+  id SERIAL PRIMARY KEY,
+  note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS share_links_note_id ON share_links (note_id);
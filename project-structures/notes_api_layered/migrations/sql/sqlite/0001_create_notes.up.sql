@@ -1,11 +1,7 @@
-package migrations
-
-const Notes = `
 CREATE TABLE IF NOT EXISTS notes (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   title VARCHAR(64) NOT NULL,
   body MEDIUMTEXT NOT NULL,
   created_at TIMESTAMP NOT NULL,
   updated_at TIMESTAMP NOT NULL
-)
-`
+);
@@ -0,0 +1,27 @@
+-- The full-text index of the notes. It is an external content table: the
+-- text is read from the notes table, which the triggers keep the index in
+-- sync with. SQLite must be built with FTS5, which go-sqlite3 does with the
+-- sqlite_fts5 build tag.
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(
+  title,
+  body,
+  content='notes',
+  content_rowid='id',
+  tokenize='porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes BEGIN
+  INSERT INTO notes_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes BEGIN
+  INSERT INTO notes_fts (notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE ON notes BEGIN
+  INSERT INTO notes_fts (notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+  INSERT INTO notes_fts (rowid, title, body) VALUES (new.id, new.title, new.body);
+END;
+
+-- Index the notes written before the triggers existed
+INSERT INTO notes_fts (notes_fts) VALUES ('rebuild');
@@ -0,0 +1,3 @@
+DROP INDEX note_tags_tag_id;
+DROP TABLE note_tags;
+DROP TABLE tags;
@@ -0,0 +1,14 @@
+-- The tags, stored once by their normalized name, and the notes they are on
+CREATE TABLE IF NOT EXISTS tags (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(32) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS note_tags (
+  note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+  tag_id INTEGER NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+  PRIMARY KEY (note_id, tag_id)
+);
+
+-- The primary key finds the tags of a note, this index the notes of a tag
+CREATE INDEX IF NOT EXISTS note_tags_tag_id ON note_tags (tag_id);
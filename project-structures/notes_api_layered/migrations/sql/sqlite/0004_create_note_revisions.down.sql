@@ -0,0 +1,2 @@
+DROP TRIGGER note_revisions_immutable;
+DROP TABLE note_revisions;
@@ -0,0 +1,20 @@
+-- Every version of the notes, numbered from 1 for each note. The rows are
+-- never changed, the trigger refuses the updates.
+CREATE TABLE IF NOT EXISTS note_revisions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+  revision INTEGER NOT NULL,
+  title VARCHAR(64) NOT NULL,
+  body MEDIUMTEXT NOT NULL,
+  author VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (note_id, revision)
+);
+
+CREATE TRIGGER IF NOT EXISTS note_revisions_immutable BEFORE UPDATE ON note_revisions BEGIN
+  SELECT RAISE(ABORT, 'note revisions are immutable');
+END;
+
+-- The notes written before the history existed start it with their current version
+INSERT INTO note_revisions (note_id, revision, title, body, author, created_at)
+SELECT id, 1, title, body, '', updated_at FROM notes;
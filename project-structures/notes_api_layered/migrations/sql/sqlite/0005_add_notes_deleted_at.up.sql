@@ -0,0 +1,6 @@
+-- The deleted notes stay in the trash, with the time they were deleted,
+-- until the purger removes them for good
+ALTER TABLE notes ADD COLUMN deleted_at TIMESTAMP;
+
+-- Only the notes in the trash are indexed, for the trash list and the purge
+CREATE INDEX IF NOT EXISTS notes_deleted_at ON notes (deleted_at) WHERE deleted_at IS NOT NULL;
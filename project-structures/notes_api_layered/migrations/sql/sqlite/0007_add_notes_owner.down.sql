@@ -0,0 +1,4 @@
+DROP TABLE share_links;
+DROP TABLE note_shares;
+DROP INDEX notes_owner;
+ALTER TABLE notes DROP COLUMN owner;
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+
+	"github.com/favtuts/problem"
+)
+
+// MaxUserLength is the length of the owner, username and author columns,
+// in characters
+const MaxUserLength = 64
+
+type userKey struct{}
+
+// WithUser returns a context acting for the user: the notes created with
+// it are theirs, the others are only seen if they are shared with the user,
+// and the changes made with it are recorded in the revisions as theirs.
+// A context without a user acts for the empty user, who owns the notes
+// written before the notes had owners.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// userFrom returns the user given to WithUser, empty if none was
+func userFrom(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// Access is what a user may do with a note
+type Access string
+
+const (
+	// AccessRead allows to read the note and its revisions
+	AccessRead Access = "read"
+	// AccessWrite also allows to update, patch and restore the note
+	AccessWrite Access = "write"
+	// AccessOwner also allows to delete and share the note
+	AccessOwner Access = "owner"
+)
+
+var (
+	ErrReadOnly = problem.New(problem.ErrForbidden, "the note is shared with you read only")
+	ErrNotOwner = problem.New(problem.ErrForbidden, "only the owner of the note can do this")
+)
+
+// allows tells whether having the access a allows what need asks for. It is
+// ErrNoteNotFound when a is empty, since the user does not see the note.
+func (a Access) allows(need Access) error {
+	switch {
+	case a == "":
+		return ErrNoteNotFound
+	case a == need || a == AccessOwner || need == AccessRead:
+		return nil
+	case need == AccessWrite:
+		return ErrReadOnly
+	}
+	return ErrNotOwner
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around the changes of a hunk
+const diffContext = 3
+
+// maxDiffCells bounds the table of the longest common subsequence. Past it,
+// the changed lines are given as removed then added, which is a correct but
+// longer diff.
+const maxDiffCells = 4_000_000
+
+// diffLine is a line of a diff: ' ' kept, '-' removed or '+' added
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the lines of a turned into the lines of b, keeping the
+// longest common subsequence of lines
+func diffLines(a, b []string) []diffLine {
+	// the common prefix and suffix are kept without searching them
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(x), len(y)
+
+	if n*m > maxDiffCells {
+		for _, line := range x {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range y {
+			lines = append(lines, diffLine{'+', line})
+		}
+	} else {
+		// lcs[i*(m+1)+j] is the length of the longest common subsequence of x[i:] and y[j:]
+		lcs := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+				} else {
+					lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && x[i] == y[j]:
+				lines = append(lines, diffLine{' ', x[i]})
+				i++
+				j++
+			case j == m || (i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]):
+				lines = append(lines, diffLine{'-', x[i]})
+				i++
+			default:
+				lines = append(lines, diffLine{'+', y[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// hunkRange formats the start and length of the lines of a hunk in one of
+// the texts. An empty range starts at the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// UnifiedDiff returns the unified diff of the lines of two texts, with
+// three lines of context around the changes, or an empty string when the
+// texts are the same.
+func UnifiedDiff(fromName, toName, a, b string) string {
+	lines := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var out strings.Builder
+	// aLine and bLine are the numbers of the lines of a and b before lines[i]
+	aLine, bLine := 0, 0
+	advance := func(line diffLine) {
+		if line.op != '+' {
+			aLine++
+		}
+		if line.op != '-' {
+			bLine++
+		}
+	}
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			advance(lines[i])
+			i++
+			continue
+		}
+		// the hunk starts a few lines before the change, and goes on while
+		// the next change is close enough to share its context
+		start := max(0, i-diffContext)
+		end := i
+		for {
+			for end < len(lines) && lines[end].op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end = min(len(lines), end+diffContext)
+
+		// the context lines before i were already counted
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, line := range lines[start:end] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, line := range lines[start:end] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		for ; i < end; i++ {
+			advance(lines[i])
+		}
+	}
+	return out.String()
+}
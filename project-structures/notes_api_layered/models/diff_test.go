@@ -0,0 +1,41 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve"
+	b := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve\nthirteen"
+	want := `--- a
++++ b
+@@ -1,5 +1,5 @@
+ one
+-two
++2
+ three
+ four
+ five
+@@ -10,3 +10,4 @@
+ ten
+ eleven
+ twelve
++thirteen
+`
+	if got := UnifiedDiff("a", "b", a, b); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// the changes sharing their context are in one hunk
+	got := UnifiedDiff("a", "b", "1\n2\n3\n4\n5\n6\n7", "1\nb\n3\n4\n5\nf\n7")
+	if strings.Count(got, "@@ -") != 1 || !strings.Contains(got, "@@ -1,7 +1,7 @@") {
+		t.Errorf("got\n%s", got)
+	}
+	if got := UnifiedDiff("a", "b", "x\n", ""); got != "--- a\n+++ b\n@@ -1,2 +1 @@\n-x\n \n" {
+		t.Errorf("removed line: got %q", got)
+	}
+	if got := UnifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("same texts: got %q", got)
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+	"gopkg.in/yaml.v3"
+)
+
+// MaxImportNotes is the number of notes an import may hold
+const MaxImportNotes = 1000
+
+var ErrNoFrontMatter = problem.New(problem.ErrValidation, "the file must start with a YAML front matter between --- lines")
+
+// NoteImport is a note to import, with its timestamps. A zero CreatedAt is
+// the time of the import, and a zero UpdatedAt is CreatedAt.
+type NoteImport struct {
+	NoteParams
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the fields of the note like NoteParams does, along with
+// the order of the timestamps
+func (p *NoteImport) Validate() error {
+	var errs ValidationError
+	validateTitle(&errs, p.Title)
+	validateBody(&errs, p.Body)
+	validateTags(&errs, "tags", p.Tags)
+	if !p.CreatedAt.IsZero() && !p.UpdatedAt.IsZero() && p.UpdatedAt.Before(p.CreatedAt) {
+		errs.Add("updated_at", "must not be before created_at")
+	}
+	return errs.Err()
+}
+
+// timestamps returns the creation and update times of the note imported at now
+func (p *NoteImport) timestamps(now time.Time) (time.Time, time.Time) {
+	created, updated := p.CreatedAt.UTC(), p.UpdatedAt.UTC()
+	if p.CreatedAt.IsZero() {
+		created = now
+	}
+	if p.UpdatedAt.IsZero() {
+		updated = created
+	}
+	return created, updated
+}
+
+// frontMatter is the YAML header of a note exported as Markdown. The id is
+// only informative, the imported notes get new ids.
+type frontMatter struct {
+	Id        int       `yaml:"id,omitempty"`
+	Title     string    `yaml:"title"`
+	CreatedAt time.Time `yaml:"created_at,omitempty"`
+	UpdatedAt time.Time `yaml:"updated_at,omitempty"`
+	Tags      []string  `yaml:"tags,flow"`
+}
+
+// MarshalMarkdown returns the note as a Markdown document: its body, after
+// a YAML front matter with the other fields
+func MarshalMarkdown(note *Note) ([]byte, error) {
+	header, err := yaml.Marshal(frontMatter{
+		Id:        note.Id,
+		Title:     note.Title,
+		CreatedAt: note.CreatedAt,
+		UpdatedAt: note.UpdatedAt,
+		Tags:      note.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	b.Write(header)
+	b.WriteString("---\n\n")
+	b.WriteString(note.Body)
+	if note.Body != "" {
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
+
+// ParseMarkdown reads a note written by MarshalMarkdown. The note is
+// neither normalized nor validated.
+func ParseMarkdown(data []byte) (*NoteImport, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, ErrNoFrontMatter
+	}
+	header, body, found := strings.Cut(text[len("---\n"):], "\n---\n")
+	if !found {
+		if !strings.HasSuffix(header, "\n---") {
+			return nil, ErrNoFrontMatter
+		}
+		header = strings.TrimSuffix(header, "\n---")
+	}
+	var front frontMatter
+	if err := yaml.Unmarshal([]byte(header), &front); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			return nil, problem.Wrap(problem.ErrValidation, errors.New("the front matter has a field of the wrong type: "+strings.Join(typeErr.Errors, "; ")))
+		}
+		return nil, problem.Wrap(problem.ErrValidation, errors.New("the front matter is not valid YAML: "+err.Error()))
+	}
+	return &NoteImport{
+		NoteParams: NoteParams{Title: front.Title, Body: body, Tags: front.Tags},
+		CreatedAt:  front.CreatedAt,
+		UpdatedAt:  front.UpdatedAt,
+	}, nil
+}
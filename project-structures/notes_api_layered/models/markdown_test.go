@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdown(t *testing.T) {
+	at := time.Date(2024, 9, 1, 12, 30, 0, 0, time.UTC)
+	note := Note{Id: 7, Title: "Coffee: a guide", Body: "# Brewing\n\n---\n\nGrind, then brew.", Tags: []string{"coffee", "recipes"}, CreatedAt: at, UpdatedAt: at.Add(time.Hour)}
+	data, err := MarshalMarkdown(&note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\nid: 7\ntitle: 'Coffee: a guide'\ncreated_at: 2024-09-01T12:30:00Z\nupdated_at: 2024-09-01T13:30:00Z\ntags: [coffee, recipes]\n---\n\n"
+	if !strings.HasPrefix(string(data), want) {
+		t.Errorf("marshal: got %q", data)
+	}
+
+	parsed, err := ParseMarkdown([]byte(strings.ReplaceAll(string(data), "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Normalize()
+	if parsed.Title != note.Title || parsed.Body != note.Body || !parsed.CreatedAt.Equal(note.CreatedAt) ||
+		!parsed.UpdatedAt.Equal(note.UpdatedAt) || strings.Join(parsed.Tags, ",") != "coffee,recipes" {
+		t.Errorf("parse: got %+v", parsed)
+	}
+
+	for _, text := range []string{"# Coffee", "---\ntitle: Coffee\n", "---\ntitle: [Coffee\n---\n", "---\ncreated_at: soon\n---\n"} {
+		if _, err := ParseMarkdown([]byte(text)); err == nil {
+			t.Errorf("parse %q: no error", text)
+		}
+	}
+	if _, err := ParseMarkdown([]byte("# Coffee")); !errors.Is(err, ErrNoFrontMatter) {
+		t.Errorf("no front matter: got %v", err)
+	}
+
+	imported := NoteImport{NoteParams: NoteParams{Title: "Coffee"}, CreatedAt: at, UpdatedAt: at.Add(-time.Hour)}
+	if err := imported.Validate(); err == nil || err.Error() != "updated_at: must not be before created_at" {
+		t.Errorf("validate: got %v", err)
+	}
+}
@@ -0,0 +1,623 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryNoteRepository is a NoteRepository keeping the notes in memory, for
+// the tests and for trying the API out. Its search has the same syntax as
+// the others, but neither stems the words nor ignores the stop words.
+type MemoryNoteRepository struct {
+	mu     sync.RWMutex
+	notes  map[int]Note
+	lastId int
+	// revisions holds the revisions of each note, the first one first
+	revisions map[int][]Revision
+	// shares holds the shares of each note by username, and links the
+	// share links by the hash of their token
+	shares     map[int]map[string]Share
+	links      map[string]ShareLink
+	lastLinkId int
+}
+
+func NewMemoryNoteRepository() *MemoryNoteRepository {
+	return &MemoryNoteRepository{
+		notes:     map[int]Note{},
+		revisions: map[int][]Revision{},
+		shares:    map[int]map[string]Share{},
+		links:     map[string]ShareLink{},
+	}
+}
+
+// access returns the access of the user to a note, empty when they do not
+// see it. The caller holds the lock.
+func (r *MemoryNoteRepository) access(ctx context.Context, id int) Access {
+	note, ok := r.notes[id]
+	user := userFrom(ctx)
+	switch {
+	case !ok || note.DeletedAt != nil:
+		return ""
+	case note.Owner == user:
+		return AccessOwner
+	}
+	return r.shares[id][user].Access
+}
+
+// authorize returns the note if the user has the access needed to it. The
+// caller holds the lock.
+func (r *MemoryNoteRepository) authorize(ctx context.Context, id int, need Access) (Note, error) {
+	return r.notes[id], r.access(ctx, id).allows(need)
+}
+
+// recordRevision records the title and body of the note as its next
+// revision. The caller holds the lock.
+func (r *MemoryNoteRepository) recordRevision(ctx context.Context, note Note) {
+	r.revisions[note.Id] = append(r.revisions[note.Id], Revision{
+		NoteId:    note.Id,
+		Number:    len(r.revisions[note.Id]) + 1,
+		Title:     note.Title,
+		Body:      note.Body,
+		Author:    userFrom(ctx),
+		CreatedAt: note.UpdatedAt,
+	})
+}
+
+// insert creates a note along with its first revision. The caller holds
+// the lock.
+func (r *MemoryNoteRepository) insert(ctx context.Context, data NoteParams, createdAt, updatedAt time.Time) Note {
+	r.lastId++
+	note := Note{Id: r.lastId, Owner: userFrom(ctx), Title: data.Title, Body: data.Body, Tags: normalizeTags(data.Tags), CreatedAt: createdAt, UpdatedAt: updatedAt, Version: 1}
+	r.notes[note.Id] = note
+	r.recordRevision(ctx, note)
+	return note
+}
+
+func (r *MemoryNoteRepository) Create(ctx context.Context, data NoteParams) (*Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	note := r.insert(ctx, data, now, now)
+	return &note, nil
+}
+
+func (r *MemoryNoteRepository) Import(ctx context.Context, notes []NoteImport) ([]Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	imported := make([]Note, 0, len(notes))
+	for _, data := range notes {
+		createdAt, updatedAt := data.timestamps(now)
+		imported = append(imported, r.insert(ctx, data.NoteParams, createdAt, updatedAt))
+	}
+	return imported, nil
+}
+
+func (r *MemoryNoteRepository) Get(ctx context.Context, id int) (*Note, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	note, err := r.authorize(ctx, id, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// changeable returns the note to change with the given version, if the user
+// has the access needed to it. The caller holds the lock.
+func (r *MemoryNoteRepository) changeable(ctx context.Context, id, version int, need Access) (Note, error) {
+	note, err := r.authorize(ctx, id, need)
+	if err != nil {
+		return note, err
+	}
+	if version != AnyVersion && version != note.Version {
+		return note, ErrVersionMismatch
+	}
+	return note, nil
+}
+
+func (r *MemoryNoteRepository) Update(ctx context.Context, id, version int, data NoteParams) (*Note, error) {
+	return r.Patch(ctx, id, version, NotePatch{Title: &data.Title, Body: &data.Body, Tags: &data.Tags})
+}
+
+func (r *MemoryNoteRepository) Patch(ctx context.Context, id, version int, data NotePatch) (*Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	note, err := r.changeable(ctx, id, version, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	if data.Title != nil {
+		note.Title = *data.Title
+	}
+	if data.Body != nil {
+		note.Body = *data.Body
+	}
+	if data.Tags != nil {
+		// a new slice, the one of the stored note is never changed
+		note.Tags = normalizeTags(*data.Tags)
+	}
+	note.UpdatedAt = time.Now().UTC()
+	note.Version++
+	r.notes[id] = note
+	r.recordRevision(ctx, note)
+	return &note, nil
+}
+
+func (r *MemoryNoteRepository) Delete(ctx context.Context, id, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	note, err := r.changeable(ctx, id, version, AccessOwner)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	note.DeletedAt = &now
+	note.Version++
+	r.notes[id] = note
+	return nil
+}
+
+func (r *MemoryNoteRepository) Undelete(ctx context.Context, id int) (*Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	note, ok := r.notes[id]
+	if !ok || note.DeletedAt == nil || note.Owner != userFrom(ctx) {
+		return nil, ErrNoteNotFound
+	}
+	note.DeletedAt = nil
+	note.Version++
+	r.notes[id] = note
+	return &note, nil
+}
+
+func (r *MemoryNoteRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, note := range r.notes {
+		if note.DeletedAt != nil && note.DeletedAt.Before(before) {
+			delete(r.notes, id)
+			delete(r.revisions, id)
+			delete(r.shares, id)
+			n++
+		}
+	}
+	for hash, link := range r.links {
+		if _, ok := r.notes[link.NoteId]; !ok {
+			delete(r.links, hash)
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryNoteRepository) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.authorize(ctx, id, AccessRead); err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(r.revisions[id]))
+	for i := len(r.revisions[id]) - 1; i >= 0; i-- {
+		revisions = append(revisions, r.revisions[id][i])
+	}
+	return revisions, nil
+}
+
+// revision returns a revision of a note the user has the access needed to.
+// The caller holds the lock.
+func (r *MemoryNoteRepository) revision(ctx context.Context, id, number int, need Access) (*Revision, error) {
+	if _, err := r.authorize(ctx, id, need); err != nil {
+		return nil, err
+	}
+	revisions := r.revisions[id]
+	if number < 1 || number > len(revisions) {
+		return nil, ErrRevisionNotFound
+	}
+	rev := revisions[number-1]
+	return &rev, nil
+}
+
+func (r *MemoryNoteRepository) Revision(ctx context.Context, id, number int) (*Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.revision(ctx, id, number, AccessRead)
+}
+
+func (r *MemoryNoteRepository) Restore(ctx context.Context, id, number, version int) (*Note, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rev, err := r.revision(ctx, id, number, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	note, err := r.changeable(ctx, id, version, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	note.Title, note.Body, note.UpdatedAt = rev.Title, rev.Body, time.Now().UTC()
+	note.Version++
+	r.notes[id] = note
+	r.recordRevision(ctx, note)
+	return &note, nil
+}
+
+// compareNotes orders two notes by the sort column, then by id
+func compareNotes(a, b Note, column string) int {
+	switch column {
+	case "title":
+		if c := strings.Compare(a.Title, b.Title); c != 0 {
+			return c
+		}
+	case "updated_at":
+		if c := a.UpdatedAt.Compare(b.UpdatedAt); c != 0 {
+			return c
+		}
+	default:
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+	}
+	return a.Id - b.Id
+}
+
+func (r *MemoryNoteRepository) List(ctx context.Context, params ListParams) (*NotePage, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	var after *Note
+	if params.Cursor != "" {
+		c, _ := decodeCursor(params.Cursor)
+		value, err := cursorArg(c)
+		if err != nil {
+			return nil, err
+		}
+		// a note with the sort value and id of the cursor, to compare the others with
+		after = &Note{Id: c.Id}
+		switch v := value.(type) {
+		case string:
+			after.Title = v
+		case time.Time:
+			after.CreatedAt, after.UpdatedAt = v, v
+		}
+	}
+	sign := 1
+	if params.Order == "desc" {
+		sign = -1
+	}
+
+	user := userFrom(ctx)
+	r.mu.RLock()
+	notes := []Note{}
+	total := 0
+	for _, note := range r.notes {
+		if params.Shared {
+			if _, ok := r.shares[note.Id][user]; !ok || note.DeletedAt != nil {
+				continue
+			}
+		} else if note.Owner != user || (note.DeletedAt != nil) != params.Trashed {
+			continue
+		}
+		if !params.CreatedFrom.IsZero() && note.CreatedAt.Before(params.CreatedFrom) {
+			continue
+		}
+		if !params.CreatedTo.IsZero() && !note.CreatedAt.Before(params.CreatedTo) {
+			continue
+		}
+		if !hasTags(note.Tags, params.Tags, params.TagMode) {
+			continue
+		}
+		total++
+		if after != nil && sign*compareNotes(note, *after, params.Sort) <= 0 {
+			continue
+		}
+		notes = append(notes, note)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(notes, func(i, j int) bool { return sign*compareNotes(notes[i], notes[j], params.Sort) < 0 })
+	if params.Offset >= len(notes) {
+		notes = notes[:0]
+	} else {
+		notes = notes[params.Offset:]
+	}
+	// one more note than asked tells whether there is a next page
+	if len(notes) > params.Limit+1 {
+		notes = notes[:params.Limit+1]
+	}
+	return newPage(notes, total, params), nil
+}
+
+// Tags counts the tags of the notes the user can read. Since the tags only exist on the notes,
+// there are no orphans to delete.
+func (r *MemoryNoteRepository) Tags(ctx context.Context) ([]TagCount, error) {
+	r.mu.RLock()
+	counts := map[string]int{}
+	for _, note := range r.notes {
+		if r.access(ctx, note.Id) == "" {
+			continue
+		}
+		for _, tag := range note.Tags {
+			counts[tag]++
+		}
+	}
+	r.mu.RUnlock()
+
+	tags := []TagCount{}
+	for name, count := range counts {
+		tags = append(tags, TagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+// word is a word of a text, with its position
+type word struct {
+	text       string
+	start, end int
+}
+
+// splitWords returns the words of s in lower case, along with their positions
+func splitWords(s string) []word {
+	var words []word
+	start := -1
+	for i, r := range s + " " {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordRune && start < 0 {
+			start = i
+		} else if !isWordRune && start >= 0 {
+			words = append(words, word{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	return words
+}
+
+// matchWords returns, for each word of words, whether it is part of a match of one of the terms,
+// and the number of matches
+func matchWords(words []word, terms []searchTerm) ([]bool, int) {
+	matched := make([]bool, len(words))
+	count := 0
+	for _, term := range terms {
+		needle := splitWords(term.Text)
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(words); i++ {
+			ok := true
+			for j, w := range needle {
+				last := j == len(needle)-1
+				if words[i+j].text != w.text && !(term.Prefix && last && strings.HasPrefix(words[i+j].text, w.text)) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				count++
+				for j := range needle {
+					matched[i+j] = true
+				}
+			}
+		}
+	}
+	return matched, count
+}
+
+// markWords wraps the matched words of s from words[from:to] in <mark> tags
+func markWords(s string, words []word, matched []bool, from, to int) string {
+	var b strings.Builder
+	pos := words[from].start
+	for i := from; i < to; i++ {
+		b.WriteString(s[pos:words[i].start])
+		if matched[i] {
+			b.WriteString("<mark>" + s[words[i].start:words[i].end] + "</mark>")
+		} else {
+			b.WriteString(s[words[i].start:words[i].end])
+		}
+		pos = words[i].end
+	}
+	return b.String()
+}
+
+// snippetWords is the number of words in a snippet
+const snippetWords = 16
+
+func (r *MemoryNoteRepository) Search(ctx context.Context, search string, limit int) ([]SearchResult, error) {
+	terms := parseSearch(search)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if limit < 1 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	results := []SearchResult{}
+	for _, note := range r.notes {
+		if r.access(ctx, note.Id) == "" {
+			continue
+		}
+		titleWords, bodyWords := splitWords(note.Title), splitWords(note.Body)
+		// every term must be found in the title or the body
+		found := true
+		for _, term := range terms {
+			_, inTitle := matchWords(titleWords, []searchTerm{term})
+			_, inBody := matchWords(bodyWords, []searchTerm{term})
+			if inTitle+inBody == 0 {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		titleMatched, titleCount := matchWords(titleWords, terms)
+		bodyMatched, bodyCount := matchWords(bodyWords, terms)
+		result := SearchResult{Note: note, Title: note.Title, Score: float64(10*titleCount + bodyCount)}
+		if len(titleWords) > 0 {
+			result.Title = note.Title[:titleWords[0].start] +
+				markWords(note.Title, titleWords, titleMatched, 0, len(titleWords)) +
+				note.Title[titleWords[len(titleWords)-1].end:]
+		}
+		if len(bodyWords) > 0 {
+			// the snippet starts a few words before the first match
+			from := 0
+			for i, m := range bodyMatched {
+				if m {
+					from = i - 3
+					break
+				}
+			}
+			if from > len(bodyWords)-snippetWords {
+				from = len(bodyWords) - snippetWords
+			}
+			if from < 0 {
+				from = 0
+			}
+			to := from + snippetWords
+			if to > len(bodyWords) {
+				to = len(bodyWords)
+			}
+			result.Snippet = markWords(note.Body, bodyWords, bodyMatched, from, to)
+			if from > 0 {
+				result.Snippet = "…" + result.Snippet
+			}
+			if to < len(bodyWords) {
+				result.Snippet += "…"
+			}
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Note.Id < results[j].Note.Id
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (r *MemoryNoteRepository) Share(ctx context.Context, id int, params ShareParams) (*Share, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	if params.Username == userFrom(ctx) {
+		return nil, ErrShareOwner
+	}
+	if r.shares[id] == nil {
+		r.shares[id] = map[string]Share{}
+	}
+	// a share that is changed keeps its creation time
+	share, ok := r.shares[id][params.Username]
+	if !ok {
+		share = Share{NoteId: id, Username: params.Username, CreatedAt: time.Now().UTC()}
+	}
+	share.Access = params.Access
+	r.shares[id][params.Username] = share
+	return &share, nil
+}
+
+func (r *MemoryNoteRepository) Unshare(ctx context.Context, id int, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return err
+	}
+	if _, ok := r.shares[id][username]; !ok {
+		return ErrShareNotFound
+	}
+	delete(r.shares[id], username)
+	return nil
+}
+
+func (r *MemoryNoteRepository) Shares(ctx context.Context, id int) ([]Share, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	shares := []Share{}
+	for _, share := range r.shares[id] {
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Username < shares[j].Username })
+	return shares, nil
+}
+
+func (r *MemoryNoteRepository) CreateLink(ctx context.Context, id int, expiresAt time.Time) (*ShareLink, error) {
+	token, hash, err := newLinkToken()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	r.lastLinkId++
+	link := ShareLink{Id: r.lastLinkId, NoteId: id, CreatedAt: time.Now().UTC(), ExpiresAt: expiresAt.UTC()}
+	r.links[hash] = link
+	link.Token = token
+	return &link, nil
+}
+
+func (r *MemoryNoteRepository) Links(ctx context.Context, id int) ([]ShareLink, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	links := []ShareLink{}
+	for _, link := range r.links {
+		if link.NoteId == id && link.ExpiresAt.After(now) {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Id < links[j].Id })
+	return links, nil
+}
+
+func (r *MemoryNoteRepository) DeleteLink(ctx context.Context, id, linkId int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.authorize(ctx, id, AccessOwner); err != nil {
+		return err
+	}
+	for hash, link := range r.links {
+		if link.Id == linkId && link.NoteId == id {
+			delete(r.links, hash)
+			return nil
+		}
+	}
+	return ErrLinkNotFound
+}
+
+func (r *MemoryNoteRepository) SharedNote(ctx context.Context, token string) (*Note, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	link, ok := r.links[hashLinkToken(token)]
+	if !ok || !link.ExpiresAt.After(time.Now()) {
+		return nil, ErrLinkNotFound
+	}
+	note, ok := r.notes[link.NoteId]
+	if !ok || note.DeletedAt != nil {
+		return nil, ErrLinkNotFound
+	}
+	return &note, nil
+}
@@ -1,65 +1,104 @@
 package models
 
 import (
-	"log"
+	"context"
 	"time"
 
-	"github.com/username/notes_api_layered/config"
+	"github.com/favtuts/problem"
 )
 
+// Note is a note, owned by the user who created it
 type Note struct {
 	Id        int       `json:"id"`
+	Owner     string    `json:"owner"`
 	Title     string    `json:"title"`
 	Body      string    `json:"body"`
+	Tags      []string  `json:"tags"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set on the notes in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version is 1 for a new note, and grows with every change
+	Version int `json:"version"`
 }
 
-type NoteParams struct {
-	Title string
-	Body  string
-}
+var (
+	ErrNoteNotFound = problem.New(problem.ErrNotFound, "note not found")
+	// ErrVersionMismatch is returned by the changes made with a version of
+	// the note that is no longer the current one
+	ErrVersionMismatch = problem.New(problem.ErrPreconditionFailed, "the note was changed since this version")
+)
 
-func (note *Note) Create(data NoteParams) (*Note, error) {
-	var created_at = time.Now().UTC()
-	var updated_at = time.Now().UTC()
-	statement, _ := config.DB.Prepare("INSERT INTO notes (title, body, created_at, updated_at) VALUES (?, ?, ?, ?)")
-	result, err := statement.Exec(data.Title, data.Body, created_at, updated_at)
-	if err == nil {
-		id, _ := result.LastInsertId()
-		note.Id = int(id)
-		note.Title = data.Title
-		note.Body = data.Body
-		note.CreatedAt = created_at
-		note.UpdatedAt = updated_at
-		return note, err
-	}
-	log.Println("Unable to create note", err.Error())
-	return note, err
+// AnyVersion is given as the version of a change to skip the version check
+const AnyVersion = 0
+
+type NoteParams struct {
+	Title string   `json:"title"`
+	Body  string   `json:"body"`
+	Tags  []string `json:"tags"`
 }
 
-func (note *Note) GetAll() ([]Note, error) {
-	rows, err := config.DB.Query("SELECT * FROM notes")
-	allNotes := []Note{}
-	if err == nil {
-		for rows.Next() {
-			var currentNote Note
-			rows.Scan(
-				&currentNote.Id,
-				&currentNote.Title,
-				&currentNote.Body,
-				&currentNote.CreatedAt,
-				&currentNote.UpdatedAt)
-			allNotes = append(allNotes, currentNote)
-		}
-		return allNotes, err
-	}
-	return allNotes, err
+// NotePatch holds the fields of a partial update, the missing ones are left unchanged
+type NotePatch struct {
+	Title *string `json:"title"`
+	Body  *string `json:"body"`
+	// Tags, when set, replace all the tags of the note
+	Tags *[]string `json:"tags"`
 }
 
-func (note *Note) Fetch(id string) (*Note, error) {
-	err := config.DB.QueryRow(
-		"SELECT id, title, body, created_at, updated_at FROM notes WHERE id=?", id).Scan(
-		&note.Id, &note.Title, &note.Body, &note.CreatedAt, &note.UpdatedAt)
-	return note, err
+// NoteRepository stores the notes of the user given to the context with
+// WithUser. The methods taking an id return ErrNoteNotFound when there is no
+// such note, or when the user does not see it, and ErrReadOnly or
+// ErrNotOwner when they see it without the access needed. The tags given to
+// Create, Update and Patch are normalized, and the tags left on no note are
+// deleted. Every change of a note records a revision, whose author is the user.
+type NoteRepository interface {
+	Create(ctx context.Context, data NoteParams) (*Note, error)
+	// Import creates the notes, with their timestamps, in one transaction:
+	// all of them or none
+	Import(ctx context.Context, notes []NoteImport) ([]Note, error)
+	// List returns a page of the notes matching the filters of params
+	List(ctx context.Context, params ListParams) (*NotePage, error)
+	Get(ctx context.Context, id int) (*Note, error)
+	// Update, Patch and Delete change the note only if it has the given
+	// version, returning ErrVersionMismatch otherwise
+	Update(ctx context.Context, id, version int, data NoteParams) (*Note, error)
+	Patch(ctx context.Context, id, version int, data NotePatch) (*Note, error)
+	// Delete moves a note to the trash, where the other methods do not see it
+	Delete(ctx context.Context, id, version int) error
+	// Undelete takes a note out of the trash, or returns ErrNoteNotFound
+	// if it is not there
+	Undelete(ctx context.Context, id int) (*Note, error)
+	// Purge permanently deletes the notes moved to the trash before the
+	// given time, and returns how many there were
+	Purge(ctx context.Context, before time.Time) (int, error)
+	// Search returns at most limit notes matching the search among the
+	// notes the user can read, best first
+	Search(ctx context.Context, search string, limit int) ([]SearchResult, error)
+	// Tags returns the tags on at least one note the user can read, the
+	// most used first
+	Tags(ctx context.Context) ([]TagCount, error)
+	// Revisions returns the revisions of a note, the latest first
+	Revisions(ctx context.Context, id int) ([]Revision, error)
+	// Revision returns a revision of a note, or ErrRevisionNotFound
+	Revision(ctx context.Context, id, number int) (*Revision, error)
+	// Restore makes the title and body of a revision current again, which
+	// records a new revision, if the note has the given version
+	Restore(ctx context.Context, id, number, version int) (*Note, error)
+	// Share gives a user access to a note, or changes the access they have.
+	// Only the owner of a note shares it and lists or removes its shares.
+	Share(ctx context.Context, id int, params ShareParams) (*Share, error)
+	// Unshare removes the access of a user, or returns ErrShareNotFound
+	Unshare(ctx context.Context, id int, username string) error
+	Shares(ctx context.Context, id int) ([]Share, error)
+	// CreateLink creates a share link to a note, returned with its token.
+	// Only the owner of a note creates its links and lists or deletes them.
+	CreateLink(ctx context.Context, id int, expiresAt time.Time) (*ShareLink, error)
+	// Links returns the links to a note that have not expired
+	Links(ctx context.Context, id int) ([]ShareLink, error)
+	// DeleteLink deletes a link to a note, or returns ErrLinkNotFound
+	DeleteLink(ctx context.Context, id, linkId int) error
+	// SharedNote returns the note of a link that has not expired, whoever
+	// the user is, or ErrLinkNotFound
+	SharedNote(ctx context.Context, token string) (*Note, error)
 }
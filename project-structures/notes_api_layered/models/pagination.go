@@ -0,0 +1,166 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+// The limits on the number of notes per page
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = problem.New(problem.ErrBadRequest, "invalid cursor")
+
+// sortColumns are the columns the notes can be sorted by
+var sortColumns = map[string]bool{"created_at": true, "updated_at": true, "title": true}
+
+// ListParams selects a page of notes. With a Cursor, the page starts after
+// the note it points to, in the order it was made for, and Offset is ignored.
+type ListParams struct {
+	Limit  int
+	Offset int
+	Cursor string
+	// Sort is created_at, updated_at or title, and Order is asc or desc
+	Sort  string
+	Order string
+	// CreatedFrom and CreatedTo, if not zero, keep the notes created in [CreatedFrom, CreatedTo)
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Tags, if not empty, keep the notes with all of them, or with any of
+	// them when TagMode is "any"
+	Tags    []string
+	TagMode string
+	// Trashed lists the notes in the trash instead of the others, and
+	// Shared the notes that other users share with the user
+	Trashed bool
+	Shared  bool
+}
+
+// NotePage is a page of notes along with what is needed to fetch the next one
+type NotePage struct {
+	Notes []Note
+	// Total is the number of notes matching the filters, on all pages
+	Total int
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// cursor points to the last note of a page. It is sent to the clients
+// encoded as base64 JSON, which they should not rely on.
+type cursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || !sortColumns[c.Sort] || (c.Order != "asc" && c.Order != "desc") {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Validate fills in the defaults and checks the values of the parameters.
+// The problems are returned as a *ValidationError.
+func (p *ListParams) Validate() error {
+	var errs ValidationError
+	if p.Limit == 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit < 1 || p.Limit > MaxLimit {
+		errs.Add("limit", fmt.Sprintf("must be between 1 and %d", MaxLimit))
+	}
+	if p.Offset < 0 {
+		errs.Add("offset", "must not be negative")
+	}
+	p.Tags = normalizeTags(p.Tags)
+	validateTags(&errs, "tag", p.Tags)
+	p.TagMode = strings.ToLower(p.TagMode)
+	if p.TagMode == "" {
+		p.TagMode = "all"
+	}
+	if p.TagMode != "all" && p.TagMode != "any" {
+		errs.Add("tag_mode", "must be all or any")
+	}
+	if p.Cursor != "" {
+		c, err := decodeCursor(p.Cursor)
+		if err != nil {
+			errs.Add("cursor", "is not a cursor given by this API")
+			return &errs
+		}
+		// the cursor only makes sense in the order it was made for
+		p.Sort, p.Order, p.Offset = c.Sort, c.Order, 0
+	}
+	if p.Sort == "" {
+		p.Sort = "created_at"
+	}
+	if !sortColumns[p.Sort] {
+		errs.Add("sort", "must be created_at, updated_at or title")
+	}
+	if p.Order == "" {
+		p.Order = "desc"
+		if p.Sort == "title" {
+			p.Order = "asc"
+		}
+	}
+	p.Order = strings.ToLower(p.Order)
+	if p.Order != "asc" && p.Order != "desc" {
+		errs.Add("order", "must be asc or desc")
+	}
+	return errs.Err()
+}
+
+// sortValue returns the value of the sort column of the note, as stored in a cursor
+func (note *Note) sortValue(column string) string {
+	switch column {
+	case "updated_at":
+		return note.UpdatedAt.Format(time.RFC3339Nano)
+	case "title":
+		return note.Title
+	}
+	return note.CreatedAt.Format(time.RFC3339Nano)
+}
+
+// cursorArg converts the value of a cursor back to the type of its column
+func cursorArg(c cursor) (any, error) {
+	if c.Sort == "title" {
+		return c.Value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t.UTC(), nil
+}
+
+// newPage returns the page of the first limit notes, the notes after them
+// telling that there is a next page
+func newPage(notes []Note, total int, params ListParams) *NotePage {
+	page := &NotePage{Notes: notes, Total: total}
+	if len(notes) > params.Limit {
+		page.Notes = notes[:params.Limit]
+		last := page.Notes[len(page.Notes)-1]
+		page.NextCursor = encodeCursor(cursor{
+			Sort:  params.Sort,
+			Order: params.Order,
+			Value: last.sortValue(params.Sort),
+			Id:    last.Id,
+		})
+	}
+	return page
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// PostgresNoteRepository is a NoteRepository backed by a PostgreSQL database,
+// opened with the pgx driver of database/sql.
+type PostgresNoteRepository struct {
+	sqlNoteRepository
+}
+
+func NewPostgresNoteRepository(db *sql.DB) *PostgresNoteRepository {
+	return &PostgresNoteRepository{sqlNoteRepository{db: db, bind: bindDollar}}
+}
+
+// tsQuery turns a search into a tsquery expression matching the notes
+// containing all of its terms, along with its arguments. The terms are
+// given to the functions that parse plain text, so that the operators of
+// the tsquery syntax are searched as text.
+func tsQuery(search string) (string, []any) {
+	var parts []string
+	var args []any
+	for _, term := range parseSearch(search) {
+		switch {
+		case term.Phrase:
+			parts = append(parts, "phraseto_tsquery('english', ?)")
+			args = append(args, term.Text)
+		case term.Prefix:
+			// to_tsquery needs the prefix alone, without any operator
+			prefix := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, term.Text)
+			if prefix == "" {
+				continue
+			}
+			parts = append(parts, "to_tsquery('english', ?)")
+			args = append(args, prefix+":*")
+		default:
+			parts = append(parts, "plainto_tsquery('english', ?)")
+			args = append(args, term.Text)
+		}
+	}
+	return strings.Join(parts, " && "), args
+}
+
+// Search ranks the notes the user can read with ts_rank, a match in the title (weight A)
+// counting ten times as much as a match in the body (weight B).
+func (r *PostgresNoteRepository) Search(ctx context.Context, search string, limit int) ([]SearchResult, error) {
+	query, args := tsQuery(search)
+	if query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if limit < 1 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+	return r.search(ctx, `
+WITH q AS (SELECT `+query+` AS query)
+SELECT notes.id, notes.owner, notes.title, notes.body, notes.created_at, notes.updated_at, notes.version,
+  ts_headline('english', notes.title, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+  ts_headline('english', notes.body, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=16, MinWords=8'),
+  ts_rank('{0.1, 0.2, 0.1, 1.0}', notes.search, q.query) AS score
+FROM notes, q
+WHERE notes.search @@ q.query AND notes.deleted_at IS NULL AND `+readable+`
+ORDER BY score DESC, notes.id
+LIMIT ?`, append(args, userFrom(ctx), userFrom(ctx), limit)...)
+}
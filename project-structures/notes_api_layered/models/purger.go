@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Purger permanently deletes the notes that have been in the trash for
+// longer than Retention, checking every Interval.
+type Purger struct {
+	Notes     NoteRepository
+	Retention time.Duration
+	Interval  time.Duration
+	// now returns the current time, replaced by the tests
+	now func() time.Time
+}
+
+func NewPurger(notes NoteRepository, retention, interval time.Duration) *Purger {
+	return &Purger{Notes: notes, Retention: retention, Interval: interval, now: time.Now}
+}
+
+// PurgeOnce deletes the notes trashed before the retention period
+func (p *Purger) PurgeOnce(ctx context.Context) (int, error) {
+	return p.Notes.Purge(ctx, p.now().Add(-p.Retention))
+}
+
+// Run purges the trash at once, then every Interval, until ctx is done. A
+// purge that is running when ctx is done is rolled back, and Run returns.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := p.PurgeOnce(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Println("Purging the trash failed:", err)
+		case n > 0:
+			log.Printf("Purged %d notes from the trash", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
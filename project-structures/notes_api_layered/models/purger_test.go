@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPurger(t *testing.T) {
+	ctx := context.Background()
+	notes := NewMemoryNoteRepository()
+	notes.Create(ctx, NoteParams{Title: "Old"})
+	notes.Delete(ctx, 1, AnyVersion)
+
+	p := NewPurger(notes, 30*24*time.Hour, time.Hour)
+	if n, err := p.PurgeOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("purge within the retention: got %d, %v", n, err)
+	}
+	p.now = func() time.Time { return time.Now().Add(31 * 24 * time.Hour) }
+	if n, err := p.PurgeOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("purge past the retention: got %d, %v", n, err)
+	}
+
+	// Run purges at once and returns when its context is canceled
+	notes.Create(ctx, NoteParams{Title: "Older"})
+	notes.Delete(ctx, 2, AnyVersion)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	for deadline := time.Now().Add(time.Second); ; {
+		if page, _ := notes.List(ctx, ListParams{Trashed: true}); page.Total == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the trash was not purged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop")
+	}
+}
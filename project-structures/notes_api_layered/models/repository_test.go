@@ -0,0 +1,696 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/username/notes_api_layered/config"
+	"github.com/username/notes_api_layered/migrations"
+)
+
+// insertFunc adds a note created and updated at the given time
+type insertFunc func(title, body string, at time.Time)
+
+// backend opens an empty repository of one kind. Every repository must pass
+// the same tests, except for stemming, which the memory one does not do.
+type backend struct {
+	name  string
+	stems bool
+	open  func(t *testing.T) (NoteRepository, insertFunc)
+}
+
+var backends = []backend{
+	{"memory", false, openMemory},
+	{"sqlite", true, openSQLite},
+	{"postgres", true, openPostgres},
+}
+
+func openMemory(t *testing.T) (NoteRepository, insertFunc) {
+	r := NewMemoryNoteRepository()
+	return r, func(title, body string, at time.Time) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.lastId++
+		r.notes[r.lastId] = Note{Id: r.lastId, Title: title, Body: body, Tags: []string{}, CreatedAt: at, UpdatedAt: at, Version: 1}
+	}
+}
+
+// sqlInsert inserts the notes of a SQL repository
+func sqlInsert(t *testing.T, r *sqlNoteRepository) insertFunc {
+	return func(title, body string, at time.Time) {
+		t.Helper()
+		_, err := r.db.Exec(r.bind("INSERT INTO notes (title, body, created_at, updated_at) VALUES (?, ?, ?, ?)"), title, body, at, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func openSQLite(t *testing.T) (NoteRepository, insertFunc) {
+	t.Helper()
+	// a file rather than :memory:, which would need a single connection, so
+	// that the concurrent changes run on several connections as they do in
+	// the server
+	db, err := config.InitializeDB(config.Config{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "notes.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrations.Run(db, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	r := NewSQLiteNoteRepository(db)
+	return r, sqlInsert(t, &r.sqlNoteRepository)
+}
+
+// openPostgres empties the notes of the database at NOTES_TEST_DATABASE_URL
+func openPostgres(t *testing.T) (NoteRepository, insertFunc) {
+	t.Helper()
+	url := os.Getenv("NOTES_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("set NOTES_TEST_DATABASE_URL to test against PostgreSQL")
+	}
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrations.Run(db, "postgres"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("TRUNCATE notes, note_tags, tags, note_revisions, note_shares, share_links RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+	r := NewPostgresNoteRepository(db)
+	return r, sqlInsert(t, &r.sqlNoteRepository)
+}
+
+func titles(notes []Note) string {
+	s := ""
+	for _, n := range notes {
+		s += n.Title
+	}
+	return s
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+
+			created, err := notes.Create(ctx, NoteParams{Title: "Title", Body: "Body"})
+			if err != nil || created.Id != 1 || created.Title != "Title" || created.CreatedAt.IsZero() {
+				t.Fatalf("create: got %+v, %v", created, err)
+			}
+			if note, err := notes.Get(ctx, created.Id); err != nil || note.Body != "Body" {
+				t.Fatalf("get: got %+v, %v", note, err)
+			}
+			if note, err := notes.Update(ctx, created.Id, AnyVersion, NoteParams{Title: "New title", Body: "New body"}); err != nil || note.Title != "New title" || note.Body != "New body" {
+				t.Fatalf("update: got %+v, %v", note, err)
+			}
+			note, err := notes.Patch(ctx, created.Id, AnyVersion, NotePatch{Title: ptr("Patched")})
+			if err != nil || note.Title != "Patched" || note.Body != "New body" || note.UpdatedAt.Before(note.CreatedAt) {
+				t.Fatalf("patch: got %+v, %v", note, err)
+			}
+			if err := notes.Delete(ctx, created.Id, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := notes.Get(ctx, created.Id); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("get a deleted note: %v", err)
+			}
+			if _, err := notes.Update(ctx, 42, AnyVersion, NoteParams{Title: "a", Body: "b"}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("update a missing note: %v", err)
+			}
+			if _, err := notes.Patch(ctx, 42, AnyVersion, NotePatch{}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("patch a missing note: %v", err)
+			}
+			if err := notes.Delete(ctx, created.Id, AnyVersion); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("delete a deleted note: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepositoryList(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, insert := b.open(t)
+			// the notes are created one hour apart
+			for i, title := range []string{"c", "a", "e", "b", "d"} {
+				insert(title, "body", start.Add(time.Duration(i)*time.Hour))
+			}
+
+			tests := []struct {
+				params ListParams
+				pages  []string
+			}{
+				{ListParams{Limit: 2}, []string{"db", "ea", "c"}},
+				{ListParams{Limit: 2, Sort: "created_at", Order: "asc"}, []string{"ca", "eb", "d"}},
+				{ListParams{Limit: 3, Sort: "title"}, []string{"abc", "de"}},
+				{ListParams{Limit: 10, CreatedFrom: start.Add(time.Hour), CreatedTo: start.Add(3 * time.Hour)}, []string{"ea"}},
+			}
+			for _, tt := range tests {
+				params := tt.params
+				var got []string
+				for {
+					page, err := notes.List(ctx, params)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, titles(page.Notes))
+					if page.NextCursor == "" {
+						break
+					}
+					params.Cursor = page.NextCursor
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.pages) {
+					t.Errorf("%+v: got pages %v, want %v", tt.params, got, tt.pages)
+				}
+			}
+
+			page, err := notes.List(ctx, ListParams{Limit: 2, Offset: 3, Sort: "title"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if titles(page.Notes) != "de" || page.Total != 5 || page.NextCursor != "" {
+				t.Errorf("offset page: got %q, total %d, next %q", titles(page.Notes), page.Total, page.NextCursor)
+			}
+
+			for _, params := range []ListParams{{Limit: MaxLimit + 1}, {Offset: -1}, {Sort: "body"}, {Order: "up"}, {Cursor: "nope"}} {
+				if _, err := notes.List(ctx, params); err == nil {
+					t.Errorf("%+v: no error", params)
+				}
+			}
+		})
+	}
+}
+
+func TestRepositorySearch(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			for _, params := range []NoteParams{
+				{Title: "Shopping list", Body: "Buy milk, bread and coffee beans"},
+				{Title: "Coffee", Body: "Notes on brewing espresso at home"},
+				{Title: "Go tutorial", Body: "Error handling with wrapped errors in Go"},
+				{Title: "Draft", Body: "Nothing here yet"},
+			} {
+				if _, err := notes.Create(ctx, params); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			search := func(q string) []SearchResult {
+				t.Helper()
+				results, err := notes.Search(ctx, q, 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return results
+			}
+
+			// a match in the title ranks first
+			results := search("coffee")
+			if len(results) != 2 || results[0].Note.Title != "Coffee" || results[0].Score <= results[1].Score {
+				t.Fatalf("coffee: got %+v", results)
+			}
+			if results[0].Title != "<mark>Coffee</mark>" || !strings.Contains(results[1].Snippet, "<mark>coffee</mark>") {
+				t.Errorf("coffee: got title %q and snippet %q", results[0].Title, results[1].Snippet)
+			}
+
+			// phrases, prefixes and stemming
+			if results := search(`"wrapped errors"`); len(results) != 1 || results[0].Note.Title != "Go tutorial" {
+				t.Errorf("phrase: got %+v", results)
+			}
+			if results := search(`"errors wrapped"`); len(results) != 0 {
+				t.Errorf("phrase in the wrong order: got %+v", results)
+			}
+			if results := search("espres*"); len(results) != 1 {
+				t.Errorf("prefix: got %+v", results)
+			}
+			if results := search("brew"); b.stems && len(results) != 1 {
+				t.Errorf("stemming: got %+v", results)
+			}
+
+			// the index follows the updates and deletions
+			if _, err := notes.Patch(ctx, 4, AnyVersion, NotePatch{Body: ptr("Tea or coffee?")}); err != nil {
+				t.Fatal(err)
+			}
+			if err := notes.Delete(ctx, 2, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if results := search("coffee"); len(results) != 2 || results[0].Note.Id == 2 || results[1].Note.Id == 2 {
+				t.Errorf("after the changes: got %+v", results)
+			}
+			if results := search("nothing"); len(results) != 0 {
+				t.Errorf("old body still indexed: got %+v", results)
+			}
+
+			if _, err := notes.Search(ctx, ` "" * `, 10); err != ErrEmptyQuery {
+				t.Errorf("empty search: got %v", err)
+			}
+		})
+	}
+}
+
+func TestRepositoryTags(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			for _, params := range []NoteParams{
+				{Title: "a", Tags: []string{"Go", "web", "go"}},
+				{Title: "b", Tags: []string{"go"}},
+				{Title: "c", Tags: []string{"Web Dev"}},
+				{Title: "d"},
+			} {
+				if _, err := notes.Create(ctx, params); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if note, err := notes.Get(ctx, 1); err != nil || fmt.Sprint(note.Tags) != "[go web]" {
+				t.Fatalf("get: got %+v, %v", note, err)
+			}
+			if note, err := notes.Get(ctx, 4); err != nil || note.Tags == nil {
+				t.Fatalf("a note without tags: got %+v, %v", note, err)
+			}
+
+			filter := func(mode string, tags ...string) string {
+				t.Helper()
+				page, err := notes.List(ctx, ListParams{Sort: "title", Tags: tags, TagMode: mode})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return titles(page.Notes)
+			}
+			if got := filter("", "go", "web"); got != "a" {
+				t.Errorf("all of go and web: got %q", got)
+			}
+			if got := filter("any", "GO", "web-dev"); got != "abc" {
+				t.Errorf("any of go and web-dev: got %q", got)
+			}
+			if got := filter("all", "nope"); got != "" {
+				t.Errorf("unknown tag: got %q", got)
+			}
+
+			// a patch without tags keeps them, the orphaned tags disappear
+			if note, err := notes.Patch(ctx, 1, AnyVersion, NotePatch{Title: ptr("A")}); err != nil || fmt.Sprint(note.Tags) != "[go web]" {
+				t.Fatalf("patch the title: got %+v, %v", note, err)
+			}
+			if note, err := notes.Patch(ctx, 3, AnyVersion, NotePatch{Tags: &[]string{"go"}}); err != nil || fmt.Sprint(note.Tags) != "[go]" {
+				t.Fatalf("patch the tags: got %+v, %v", note, err)
+			}
+			if _, err := notes.Update(ctx, 1, AnyVersion, NoteParams{Title: "A", Tags: []string{"draft"}}); err != nil {
+				t.Fatal(err)
+			}
+			tags, err := notes.Tags(ctx)
+			if err != nil || fmt.Sprint(tags) != "[{go 2} {draft 1}]" {
+				t.Errorf("tags: got %v, %v", tags, err)
+			}
+			if err := notes.Delete(ctx, 1, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if tags, err := notes.Tags(ctx); err != nil || fmt.Sprint(tags) != "[{go 2}]" {
+				t.Errorf("tags after the deletion: got %v, %v", tags, err)
+			}
+			// the tags of the trashed notes are kept until they are purged
+			if _, err := notes.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			var db *sql.DB
+			switch r := notes.(type) {
+			case *SQLiteNoteRepository:
+				db = r.db
+			case *PostgresNoteRepository:
+				db = r.db
+			}
+			var n int
+			if db != nil && (db.QueryRow("SELECT COUNT(*) FROM tags").Scan(&n) != nil || n != 1) {
+				t.Errorf("%d tags left in the tags table, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRepositoryRevisions(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			ctx := WithUser(context.Background(), "alice")
+			if _, err := notes.Create(ctx, NoteParams{Title: "Coffee", Body: "Espresso"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := notes.Share(ctx, 1, ShareParams{Username: "bob", Access: AccessWrite}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := notes.Update(WithUser(ctx, "bob"), 1, AnyVersion, NoteParams{Title: "Coffee", Body: "Filter"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := notes.Patch(ctx, 1, AnyVersion, NotePatch{Title: ptr("Brewing")}); err != nil {
+				t.Fatal(err)
+			}
+
+			revisions, err := notes.Revisions(ctx, 1)
+			if err != nil || len(revisions) != 3 {
+				t.Fatalf("revisions: got %+v, %v", revisions, err)
+			}
+			if r := revisions[0]; r.Number != 3 || r.Title != "Brewing" || r.Body != "Filter" || r.Author != "alice" {
+				t.Errorf("latest revision: got %+v", r)
+			}
+			if r := revisions[1]; r.Number != 2 || r.Author != "bob" || r.CreatedAt.IsZero() {
+				t.Errorf("second revision: got %+v", r)
+			}
+
+			if _, err := notes.Restore(ctx, 1, 1, 2); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("restore an old version: %v", err)
+			}
+			note, err := notes.Restore(ctx, 1, 1, 3)
+			if err != nil || note.Title != "Coffee" || note.Body != "Espresso" {
+				t.Fatalf("restore: got %+v, %v", note, err)
+			}
+			if rev, err := notes.Revision(ctx, 1, 4); err != nil || rev.Title != "Coffee" || rev.Body != "Espresso" {
+				t.Errorf("restored revision: got %+v, %v", rev, err)
+			}
+			if _, err := notes.Revision(ctx, 1, 5); !errors.Is(err, ErrRevisionNotFound) {
+				t.Errorf("missing revision: %v", err)
+			}
+			if _, err := notes.Restore(ctx, 42, 1, AnyVersion); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("restore a missing note: %v", err)
+			}
+			if err := notes.Delete(ctx, 1, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := notes.Revisions(ctx, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("revisions of a deleted note: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepositoryTrash(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			for _, title := range []string{"Coffee", "Tea", "Cocoa"} {
+				if _, err := notes.Create(ctx, NoteParams{Title: title, Body: "a drink", Tags: []string{"drinks"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := notes.Delete(ctx, 1, AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			if err := notes.Delete(ctx, 1, AnyVersion); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("delete a trashed note: %v", err)
+			}
+
+			// the trashed note is hidden from everything but the trash
+			if _, err := notes.Get(ctx, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("get a trashed note: %v", err)
+			}
+			if _, err := notes.Patch(ctx, 1, AnyVersion, NotePatch{Title: ptr("x")}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("patch a trashed note: %v", err)
+			}
+			if _, err := notes.Revisions(ctx, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("revisions of a trashed note: %v", err)
+			}
+			list := func(trashed bool) string {
+				t.Helper()
+				page, err := notes.List(ctx, ListParams{Sort: "title", Trashed: trashed})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return titles(page.Notes)
+			}
+			if got := list(false); got != "CocoaTea" {
+				t.Errorf("list: got %q", got)
+			}
+			if got := list(true); got != "Coffee" {
+				t.Errorf("trash: got %q", got)
+			}
+			if tags, err := notes.Tags(ctx); err != nil || fmt.Sprint(tags) != "[{drinks 2}]" {
+				t.Errorf("tags: got %v, %v", tags, err)
+			}
+
+			note, err := notes.Undelete(ctx, 1)
+			if err != nil || note.Title != "Coffee" || note.DeletedAt != nil || fmt.Sprint(note.Tags) != "[drinks]" {
+				t.Fatalf("undelete: got %+v, %v", note, err)
+			}
+			if _, err := notes.Undelete(ctx, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("undelete a note out of the trash: %v", err)
+			}
+
+			// only the notes trashed before the cutoff are purged
+			notes.Delete(ctx, 1, AnyVersion)
+			cutoff := time.Now().Add(time.Minute)
+			if n, err := notes.Purge(ctx, cutoff.Add(-time.Hour)); err != nil || n != 0 {
+				t.Errorf("purge before the deletion: got %d, %v", n, err)
+			}
+			if n, err := notes.Purge(ctx, cutoff); err != nil || n != 1 {
+				t.Errorf("purge: got %d, %v", n, err)
+			}
+			if got := list(true); got != "" {
+				t.Errorf("trash after the purge: got %q", got)
+			}
+			if _, err := notes.Undelete(ctx, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("undelete a purged note: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepositoryVersions(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			created, err := notes.Create(ctx, NoteParams{Title: "Coffee"})
+			if err != nil || created.Version != 1 {
+				t.Fatalf("create: got %+v, %v", created, err)
+			}
+			note, err := notes.Patch(ctx, 1, 1, NotePatch{Body: ptr("Espresso")})
+			if err != nil || note.Version != 2 {
+				t.Fatalf("patch: got %+v, %v", note, err)
+			}
+			if _, err := notes.Update(ctx, 1, 1, NoteParams{Title: "Tea"}); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("update an old version: %v", err)
+			}
+			if err := notes.Delete(ctx, 1, 1); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("delete an old version: %v", err)
+			}
+			if _, err := notes.Patch(ctx, 2, 1, NotePatch{}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("patch a missing note: %v", err)
+			}
+			if note, err := notes.Get(ctx, 1); err != nil || note.Title != "Coffee" || note.Version != 2 {
+				t.Errorf("the refused changes changed the note: got %+v, %v", note, err)
+			}
+			if revisions, err := notes.Revisions(ctx, 1); err != nil || len(revisions) != 2 {
+				t.Errorf("the refused changes recorded revisions: got %d, %v", len(revisions), err)
+			}
+
+			// clients updating or patching the version they read at the
+			// same time: one of them wins, the others are told the note changed
+			const clients = 8
+			var wg sync.WaitGroup
+			errs := make([]error, clients)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					title := fmt.Sprint("Client ", i)
+					if i%2 == 0 {
+						_, errs[i] = notes.Update(ctx, 1, 2, NoteParams{Title: title})
+					} else {
+						_, errs[i] = notes.Patch(ctx, 1, 2, NotePatch{Title: &title})
+					}
+				}(i)
+			}
+			wg.Wait()
+			winner := -1
+			for i, err := range errs {
+				switch {
+				case err == nil && winner < 0:
+					winner = i
+				case !errors.Is(err, ErrVersionMismatch):
+					t.Errorf("client %d: %v", i, err)
+				}
+			}
+			note, err = notes.Get(ctx, 1)
+			if winner < 0 || err != nil || note.Title != fmt.Sprint("Client ", winner) || note.Version != 3 {
+				t.Errorf("concurrent updates: winner %d, got %+v, %v", winner, note, err)
+			}
+
+			if err := notes.Delete(ctx, 1, 3); err != nil {
+				t.Fatal(err)
+			}
+			if note, err := notes.Undelete(ctx, 1); err != nil || note.Version != 5 {
+				t.Errorf("undelete: got %+v, %v", note, err)
+			}
+		})
+	}
+}
+
+func TestRepositoryImport(t *testing.T) {
+	ctx := WithUser(context.Background(), "alice")
+	at := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			notes.Create(ctx, NoteParams{Title: "Existing"})
+			imported, err := notes.Import(ctx, []NoteImport{
+				{NoteParams: NoteParams{Title: "Coffee", Body: "Grind", Tags: []string{"drinks"}}, CreatedAt: at, UpdatedAt: at.Add(time.Hour)},
+				{NoteParams: NoteParams{Title: "Tea"}},
+			})
+			if err != nil || len(imported) != 2 {
+				t.Fatalf("import: got %+v, %v", imported, err)
+			}
+			coffee, tea := imported[0], imported[1]
+			if coffee.Id != 2 || !coffee.CreatedAt.Equal(at) || !coffee.UpdatedAt.Equal(at.Add(time.Hour)) || coffee.Version != 1 {
+				t.Errorf("coffee: got %+v", coffee)
+			}
+			if tea.Id != 3 || tea.CreatedAt.IsZero() || !tea.UpdatedAt.Equal(tea.CreatedAt) {
+				t.Errorf("tea: got %+v", tea)
+			}
+			if note, err := notes.Get(ctx, 2); err != nil || fmt.Sprint(note.Tags) != "[drinks]" || !note.CreatedAt.Equal(at) {
+				t.Errorf("get: got %+v, %v", note, err)
+			}
+			revisions, err := notes.Revisions(ctx, 2)
+			if err != nil || len(revisions) != 1 || revisions[0].Author != "alice" || revisions[0].Body != "Grind" {
+				t.Errorf("revisions: got %+v, %v", revisions, err)
+			}
+			page, err := notes.List(ctx, ListParams{Sort: "created_at", Order: "asc"})
+			if err != nil || titles(page.Notes) != "CoffeeExistingTea" {
+				t.Errorf("list: got %+v, %v", page, err)
+			}
+		})
+	}
+}
+
+func TestRepositorySharing(t *testing.T) {
+	alice := WithUser(context.Background(), "alice")
+	bob := WithUser(context.Background(), "bob")
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			notes, _ := b.open(t)
+			for _, title := range []string{"Coffee", "Tea"} {
+				if _, err := notes.Create(alice, NoteParams{Title: title, Tags: []string{"drinks"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if note, err := notes.Create(bob, NoteParams{Title: "Cocoa"}); err != nil || note.Owner != "bob" {
+				t.Fatalf("create: got %+v, %v", note, err)
+			}
+
+			// the notes of other users are hidden until they are shared
+			if _, err := notes.Get(bob, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("get a note of another user: %v", err)
+			}
+			if page, err := notes.List(bob, ListParams{Sort: "title"}); err != nil || titles(page.Notes) != "Cocoa" {
+				t.Errorf("list: got %+v, %v", page, err)
+			}
+			if tags, err := notes.Tags(bob); err != nil || len(tags) != 0 {
+				t.Errorf("tags: got %v, %v", tags, err)
+			}
+			if _, err := notes.Share(bob, 1, ShareParams{Username: "carol", Access: AccessRead}); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("share a note of another user: %v", err)
+			}
+			if _, err := notes.Share(alice, 1, ShareParams{Username: "alice", Access: AccessRead}); !errors.Is(err, ErrShareOwner) {
+				t.Errorf("share with the owner: %v", err)
+			}
+
+			// a read share allows reading, a write share changing, and only
+			// the owner deletes
+			share, err := notes.Share(alice, 1, ShareParams{Username: "bob", Access: AccessRead})
+			if err != nil || share.Access != AccessRead || share.CreatedAt.IsZero() {
+				t.Fatalf("share: got %+v, %v", share, err)
+			}
+			if note, err := notes.Get(bob, 1); err != nil || note.Owner != "alice" {
+				t.Errorf("get a shared note: got %+v, %v", note, err)
+			}
+			if _, err := notes.Patch(bob, 1, AnyVersion, NotePatch{Body: ptr("Filter")}); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("patch a note shared read only: %v", err)
+			}
+			if _, err := notes.Share(alice, 1, ShareParams{Username: "bob", Access: AccessWrite}); err != nil {
+				t.Fatal(err)
+			}
+			if note, err := notes.Patch(bob, 1, AnyVersion, NotePatch{Body: ptr("Filter")}); err != nil || note.Body != "Filter" {
+				t.Errorf("patch a note shared to write: got %+v, %v", note, err)
+			}
+			if err := notes.Delete(bob, 1, AnyVersion); !errors.Is(err, ErrNotOwner) {
+				t.Errorf("delete a shared note: %v", err)
+			}
+			if _, err := notes.Shares(bob, 1); !errors.Is(err, ErrNotOwner) {
+				t.Errorf("shares of a shared note: %v", err)
+			}
+			if shares, err := notes.Shares(alice, 1); err != nil || len(shares) != 1 || shares[0].Username != "bob" || shares[0].Access != AccessWrite {
+				t.Errorf("shares: got %+v, %v", shares, err)
+			}
+			if page, err := notes.List(bob, ListParams{Sort: "title", Shared: true}); err != nil || titles(page.Notes) != "Coffee" {
+				t.Errorf("list shared: got %+v, %v", page, err)
+			}
+			// the search and the tags see the notes shared with the user too
+			if results, err := notes.Search(bob, "filter", 10); err != nil || len(results) != 1 || results[0].Note.Id != 1 {
+				t.Errorf("search a shared note: got %+v, %v", results, err)
+			}
+			if tags, err := notes.Tags(bob); err != nil || fmt.Sprint(tags) != "[{drinks 1}]" {
+				t.Errorf("tags of a shared note: got %v, %v", tags, err)
+			}
+			if err := notes.Unshare(alice, 1, "bob"); err != nil {
+				t.Fatal(err)
+			}
+			if err := notes.Unshare(alice, 1, "bob"); !errors.Is(err, ErrShareNotFound) {
+				t.Errorf("unshare twice: %v", err)
+			}
+			if _, err := notes.Get(bob, 1); !errors.Is(err, ErrNoteNotFound) {
+				t.Errorf("get an unshared note: %v", err)
+			}
+			if results, err := notes.Search(bob, "filter", 10); err != nil || len(results) != 0 {
+				t.Errorf("search an unshared note: got %+v, %v", results, err)
+			}
+
+			// a link reads the note until it expires or is deleted
+			link, err := notes.CreateLink(alice, 2, time.Now().Add(time.Hour))
+			if err != nil || link.Token == "" {
+				t.Fatalf("create link: got %+v, %v", link, err)
+			}
+			expired, err := notes.CreateLink(alice, 2, time.Now().Add(-time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if note, err := notes.SharedNote(context.Background(), link.Token); err != nil || note.Title != "Tea" || fmt.Sprint(note.Tags) != "[drinks]" {
+				t.Errorf("shared note: got %+v, %v", note, err)
+			}
+			for _, token := range []string{expired.Token, "nope"} {
+				if _, err := notes.SharedNote(context.Background(), token); !errors.Is(err, ErrLinkNotFound) {
+					t.Errorf("shared note of %q: %v", token, err)
+				}
+			}
+			if links, err := notes.Links(alice, 2); err != nil || len(links) != 1 || links[0].Id != link.Id || links[0].Token != "" {
+				t.Errorf("links: got %+v, %v", links, err)
+			}
+			if err := notes.DeleteLink(alice, 2, link.Id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := notes.SharedNote(context.Background(), link.Token); !errors.Is(err, ErrLinkNotFound) {
+				t.Errorf("shared note of a deleted link: %v", err)
+			}
+			if err := notes.DeleteLink(alice, 2, link.Id); !errors.Is(err, ErrLinkNotFound) {
+				t.Errorf("delete a link twice: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/favtuts/problem"
+)
+
+// Revision is a version of a note, recorded when the note was created,
+// updated, patched or restored. Revisions are never changed.
+type Revision struct {
+	NoteId    int       `json:"note_id"`
+	Number    int       `json:"revision"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var ErrRevisionNotFound = problem.New(problem.ErrNotFound, "revision not found")
+
+// text is the content of a revision as compared by Diff: the title, a blank
+// line and the body
+func (rev *Revision) text() string {
+	return rev.Title + "\n\n" + rev.Body
+}
+
+// Diff returns the unified diff turning the revision into the note, empty
+// when they have the same title and body
+func (rev *Revision) Diff(note *Note) string {
+	current := Revision{Title: note.Title, Body: note.Body}
+	return UnifiedDiff(
+		fmt.Sprintf("note %d revision %d", rev.NoteId, rev.Number),
+		fmt.Sprintf("note %d current", note.Id),
+		rev.text(), current.text())
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/favtuts/problem"
+)
+
+var ErrEmptyQuery = problem.New(problem.ErrBadRequest, "the search query is empty")
+
+// SearchResult is a note matching a search, with the matches in its title
+// and in an excerpt of its text wrapped in <mark> tags
+type SearchResult struct {
+	Note    Note   `json:"note"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+	// Score is the relevance of the note, higher is better. It is only
+	// comparable between the results of the same search
+	Score float64 `json:"score"`
+}
+
+// searchTerm is a word, a prefix or a phrase that a note must contain to match a search
+type searchTerm struct {
+	Text   string
+	Phrase bool
+	Prefix bool
+}
+
+// parseSearch splits a search typed by a user into terms. "quoted words"
+// are a phrase, and a word ending with * is a prefix.
+func parseSearch(search string) []searchTerm {
+	var terms []searchTerm
+	rest := search
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			return terms
+		}
+		if rest[0] == '"' {
+			// a phrase runs to the closing quote, or to the end of the search
+			phrase, after, _ := strings.Cut(rest[1:], `"`)
+			rest = after
+			if strings.TrimSpace(phrase) != "" {
+				terms = append(terms, searchTerm{Text: phrase, Phrase: true})
+			}
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word != "" {
+			terms = append(terms, searchTerm{Text: word, Prefix: prefix})
+		}
+	}
+}
+
+// ftsQuery turns a search into a FTS5 query matching the notes containing
+// all of its terms. Every term is quoted, so that the other characters of
+// the FTS5 syntax are searched as text.
+func ftsQuery(search string) string {
+	var query []string
+	for _, term := range parseSearch(search) {
+		quoted := `"` + strings.ReplaceAll(term.Text, `"`, `""`) + `"`
+		if term.Prefix {
+			quoted += "*"
+		}
+		query = append(query, quoted)
+	}
+	return strings.Join(query, " ")
+}
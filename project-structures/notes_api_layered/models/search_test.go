@@ -0,0 +1,34 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := map[string]string{
+		"go tutorial":          `"go" "tutorial"`,
+		`"error handling" go*`: `"error handling" "go"*`,
+		`AND OR NOT - (x)`:     `"AND" "OR" "NOT" "-" "(x)"`,
+		`"unclosed phrase`:     `"unclosed phrase"`,
+		`say "hi" * ""`:        `"say" "hi"`,
+		"   ":                  "",
+	}
+	for search, want := range tests {
+		if got := ftsQuery(search); got != want {
+			t.Errorf("ftsQuery(%q) = %s, want %s", search, got, want)
+		}
+	}
+}
+
+func TestTsQuery(t *testing.T) {
+	query, args := tsQuery(`go "error handling" tut* ,* & !`)
+	want := "plainto_tsquery('english', ?) && phraseto_tsquery('english', ?) && to_tsquery('english', ?) && plainto_tsquery('english', ?) && plainto_tsquery('english', ?)"
+	if query != want || fmt.Sprint(args) != "[go error handling tut:* & !]" {
+		t.Errorf("got %s %q", query, args)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
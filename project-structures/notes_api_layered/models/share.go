@@ -0,0 +1,118 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/favtuts/problem"
+)
+
+// Share gives a user other than the owner access to a note
+type Share struct {
+	NoteId    int       `json:"note_id"`
+	Username  string    `json:"username"`
+	Access    Access    `json:"access"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareParams are the fields of a new share, or of a changed one
+type ShareParams struct {
+	Username string `json:"-"`
+	Access   Access `json:"access"`
+}
+
+// Normalize trims the spaces around the username
+func (p *ShareParams) Normalize() {
+	p.Username = strings.TrimSpace(p.Username)
+}
+
+// Validate checks the username and the access, which is read or write
+func (p *ShareParams) Validate() error {
+	var errs ValidationError
+	switch {
+	case p.Username == "":
+		errs.Add("username", "is required")
+	case utf8.RuneCountInString(p.Username) > MaxUserLength:
+		errs.Add("username", "must be at most 64 characters")
+	case strings.IndexFunc(p.Username, unicode.IsControl) >= 0:
+		errs.Add("username", "must be a single line of text")
+	}
+	if p.Access != AccessRead && p.Access != AccessWrite {
+		errs.Add("access", "must be read or write")
+	}
+	return errs.Err()
+}
+
+// ShareLink gives anyone with its token read access to a note until it
+// expires. The token is only known when the link is created.
+type ShareLink struct {
+	Id        int       `json:"id"`
+	NoteId    int       `json:"note_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Token is only set on the links that were just created
+	Token string `json:"token,omitempty"`
+}
+
+// The lifetime of the share links
+const (
+	DefaultLinkLifetime = 7 * 24 * time.Hour
+	MaxLinkLifetime     = 90 * 24 * time.Hour
+)
+
+// LinkParams are the fields of a new share link: its lifetime in seconds,
+// DefaultLinkLifetime when zero
+type LinkParams struct {
+	ExpiresIn int `json:"expires_in"`
+}
+
+// Normalize sets the default lifetime
+func (p *LinkParams) Normalize() {
+	if p.ExpiresIn == 0 {
+		p.ExpiresIn = int(DefaultLinkLifetime / time.Second)
+	}
+}
+
+// Validate checks that the lifetime is positive and at most MaxLinkLifetime
+func (p *LinkParams) Validate() error {
+	var errs ValidationError
+	if p.ExpiresIn < 1 || p.ExpiresIn > int(MaxLinkLifetime/time.Second) {
+		errs.Add("expires_in", "must be between 1 and 7776000 seconds (90 days)")
+	}
+	return errs.Err()
+}
+
+// ExpiresAt returns the expiry of a link created at now
+func (p *LinkParams) ExpiresAt(now time.Time) time.Time {
+	return now.Add(time.Duration(p.ExpiresIn) * time.Second)
+}
+
+var (
+	ErrShareNotFound = problem.New(problem.ErrNotFound, "share not found")
+	ErrLinkNotFound  = problem.New(problem.ErrNotFound, "share link not found or expired")
+	ErrShareOwner    = problem.New(problem.ErrValidation, "the owner of a note cannot share it with themselves")
+)
+
+// newLinkToken returns a random token of 256 bits, and the hash of it that
+// is stored
+func newLinkToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashLinkToken(token), nil
+}
+
+// hashLinkToken returns the hex SHA-256 of a token. The tokens are random,
+// so a plain hash is enough to keep the stored ones useless.
+func hashLinkToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,714 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlNoteRepository holds the queries shared by the SQLite and PostgreSQL
+// repositories. They are written with ? placeholders, which bind rewrites
+// for the database.
+type sqlNoteRepository struct {
+	db   *sql.DB
+	bind func(query string) string
+}
+
+// bindDollar rewrites the ? placeholders of a query as $1, $2... for PostgreSQL
+func bindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+const noteColumns = "id, owner, title, body, created_at, updated_at, deleted_at, version"
+
+func scanNote(row interface{ Scan(...any) error }) (*Note, error) {
+	var note Note
+	var deletedAt sql.NullTime
+	err := row.Scan(&note.Id, &note.Owner, &note.Title, &note.Body, &note.CreatedAt, &note.UpdatedAt, &deletedAt, &note.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoteNotFound
+	}
+	if deletedAt.Valid {
+		note.DeletedAt = &deletedAt.Time
+	}
+	return &note, err
+}
+
+// querier runs queries on the database or in a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a transaction, committed if fn returns no error
+func (r *sqlNoteRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// placeholders returns n comma separated ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// loadTags sets the tags of the notes
+func (r *sqlNoteRepository) loadTags(ctx context.Context, q querier, notes ...*Note) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	byId := make(map[int]*Note, len(notes))
+	ids := make([]any, len(notes))
+	for i, note := range notes {
+		note.Tags = []string{}
+		byId[note.Id] = note
+		ids[i] = note.Id
+	}
+	rows, err := q.QueryContext(ctx, r.bind(
+		"SELECT note_tags.note_id, tags.name FROM note_tags JOIN tags ON tags.id = note_tags.tag_id"+
+			" WHERE note_tags.note_id IN ("+placeholders(len(ids))+") ORDER BY tags.name"), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byId[id].Tags = append(byId[id].Tags, name)
+	}
+	return rows.Err()
+}
+
+// setTags replaces the tags of the note, creating the new ones and deleting
+// the ones left on no note
+func (r *sqlNoteRepository) setTags(ctx context.Context, tx *sql.Tx, note *Note, tags []string) error {
+	note.Tags = normalizeTags(tags)
+	if _, err := tx.ExecContext(ctx, r.bind("DELETE FROM note_tags WHERE note_id = ?"), note.Id); err != nil {
+		return err
+	}
+	for _, name := range note.Tags {
+		// the update of an existing tag locks it until the end of the
+		// transaction, so that a concurrent collectTags cannot delete it
+		var tagId int
+		err := tx.QueryRowContext(ctx, r.bind(
+			"INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO UPDATE SET name = excluded.name RETURNING id"),
+			name).Scan(&tagId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, r.bind("INSERT INTO note_tags (note_id, tag_id) VALUES (?, ?)"), note.Id, tagId)
+		if err != nil {
+			return err
+		}
+	}
+	return r.collectTags(ctx, tx)
+}
+
+// collectTags deletes the tags that are on no note
+func (r *sqlNoteRepository) collectTags(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM note_tags WHERE note_tags.tag_id = tags.id)")
+	return err
+}
+
+// insert creates a note along with its first revision
+func (r *sqlNoteRepository) insert(ctx context.Context, tx *sql.Tx, data NoteParams, createdAt, updatedAt time.Time) (*Note, error) {
+	note, err := scanNote(tx.QueryRowContext(ctx, r.bind(
+		"INSERT INTO notes (owner, title, body, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING "+noteColumns),
+		userFrom(ctx), data.Title, data.Body, createdAt, updatedAt))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.recordRevision(ctx, tx, note); err != nil {
+		return nil, err
+	}
+	return note, r.setTags(ctx, tx, note, data.Tags)
+}
+
+func (r *sqlNoteRepository) Create(ctx context.Context, data NoteParams) (*Note, error) {
+	var note *Note
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		var err error
+		note, err = r.insert(ctx, tx, data, now, now)
+		return err
+	})
+	return note, err
+}
+
+func (r *sqlNoteRepository) Import(ctx context.Context, notes []NoteImport) ([]Note, error) {
+	imported := make([]Note, 0, len(notes))
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		for _, data := range notes {
+			createdAt, updatedAt := data.timestamps(now)
+			note, err := r.insert(ctx, tx, data.NoteParams, createdAt, updatedAt)
+			if err != nil {
+				return err
+			}
+			imported = append(imported, *note)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// readable is the condition on the notes the user sees, their own and the
+// ones shared with them, whose argument is the user given twice
+const readable = "(notes.owner = ? OR notes.id IN (SELECT note_id FROM note_shares WHERE username = ?))"
+
+func (r *sqlNoteRepository) Get(ctx context.Context, id int) (*Note, error) {
+	user := userFrom(ctx)
+	note, err := scanNote(r.db.QueryRowContext(ctx, r.bind(
+		"SELECT "+noteColumns+" FROM notes WHERE id = ? AND deleted_at IS NULL AND "+readable), id, user, user))
+	if err != nil {
+		return nil, err
+	}
+	return note, r.loadTags(ctx, r.db, note)
+}
+
+// versionCheck is the condition of the changes on the version of the note,
+// whose arguments are given twice. A concurrent change waits for the first
+// one to commit and then checks the version it committed: PostgreSQL locks
+// the row of the note on the first change, and SQLite the whole database
+// when the transaction begins, which the DSN must ask for with
+// _txlock=immediate as config.InitializeDB does.
+const versionCheck = " AND (? = 0 OR version = ?)"
+
+// changeError tells why a change of the note matched no row: ErrNoteNotFound
+// when there is no such note and ErrVersionMismatch when it has another version
+func (r *sqlNoteRepository) changeError(ctx context.Context, q querier, id int, err error) error {
+	if errors.Is(err, ErrNoteNotFound) && r.exists(ctx, q, id) == nil {
+		return ErrVersionMismatch
+	}
+	return err
+}
+
+func (r *sqlNoteRepository) Update(ctx context.Context, id, version int, data NoteParams) (*Note, error) {
+	var note *Note
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		err := r.authorize(ctx, tx, id, AccessWrite)
+		if err != nil {
+			return err
+		}
+		note, err = scanNote(tx.QueryRowContext(ctx, r.bind(
+			"UPDATE notes SET title = ?, body = ?, updated_at = ?, version = version + 1"+
+				" WHERE id = ? AND deleted_at IS NULL"+versionCheck+" RETURNING "+noteColumns),
+			data.Title, data.Body, time.Now().UTC(), id, version, version))
+		if err != nil {
+			return r.changeError(ctx, tx, id, err)
+		}
+		if err := r.recordRevision(ctx, tx, note); err != nil {
+			return err
+		}
+		return r.setTags(ctx, tx, note, data.Tags)
+	})
+	return note, err
+}
+
+// Patch changes only the fields that are set, in a single statement so that
+// it cannot overwrite a concurrent change of the other fields
+func (r *sqlNoteRepository) Patch(ctx context.Context, id, version int, data NotePatch) (*Note, error) {
+	var note *Note
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		err := r.authorize(ctx, tx, id, AccessWrite)
+		if err != nil {
+			return err
+		}
+		note, err = scanNote(tx.QueryRowContext(ctx, r.bind(
+			"UPDATE notes SET title = COALESCE(?, title), body = COALESCE(?, body), updated_at = ?, version = version + 1"+
+				" WHERE id = ? AND deleted_at IS NULL"+versionCheck+" RETURNING "+noteColumns),
+			data.Title, data.Body, time.Now().UTC(), id, version, version))
+		if err != nil {
+			return r.changeError(ctx, tx, id, err)
+		}
+		if err := r.recordRevision(ctx, tx, note); err != nil {
+			return err
+		}
+		if data.Tags != nil {
+			return r.setTags(ctx, tx, note, *data.Tags)
+		}
+		return r.loadTags(ctx, tx, note)
+	})
+	return note, err
+}
+
+// Delete moves the note to the trash, keeping its tags and revisions
+func (r *sqlNoteRepository) Delete(ctx context.Context, id, version int) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.authorize(ctx, tx, id, AccessOwner); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, r.bind(
+			"UPDATE notes SET deleted_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL"+versionCheck),
+			time.Now().UTC(), id, version, version)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return r.changeError(ctx, tx, id, ErrNoteNotFound)
+		}
+		return nil
+	})
+}
+
+// Undelete only sees the notes of the user, the trash is not shared
+func (r *sqlNoteRepository) Undelete(ctx context.Context, id int) (*Note, error) {
+	note, err := scanNote(r.db.QueryRowContext(ctx, r.bind(
+		"UPDATE notes SET deleted_at = NULL, version = version + 1 WHERE id = ? AND owner = ? AND deleted_at IS NOT NULL RETURNING "+noteColumns),
+		id, userFrom(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	return note, r.loadTags(ctx, r.db, note)
+}
+
+func (r *sqlNoteRepository) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int64
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		// the foreign keys of SQLite are only enforced when enabled, so the
+		// tags, revisions, shares and links of the notes are removed here
+		// rather than by ON DELETE CASCADE
+		const trashed = "SELECT id FROM notes WHERE deleted_at < ?"
+		cutoff := before.UTC()
+		for _, table := range []string{"note_tags", "note_revisions", "note_shares", "share_links"} {
+			if _, err := tx.ExecContext(ctx, r.bind("DELETE FROM "+table+" WHERE note_id IN ("+trashed+")"), cutoff); err != nil {
+				return err
+			}
+		}
+		result, err := tx.ExecContext(ctx, r.bind("DELETE FROM notes WHERE deleted_at < ?"), cutoff)
+		if err != nil {
+			return err
+		}
+		n, _ = result.RowsAffected()
+		return r.collectTags(ctx, tx)
+	})
+	return int(n), err
+}
+
+func (r *sqlNoteRepository) Tags(ctx context.Context) ([]TagCount, error) {
+	rows, err := r.db.QueryContext(ctx, r.bind(
+		"SELECT tags.name, COUNT(*) AS count FROM tags JOIN note_tags ON note_tags.tag_id = tags.id"+
+			" JOIN notes ON notes.id = note_tags.note_id WHERE notes.deleted_at IS NULL AND "+readable+
+			" GROUP BY tags.name ORDER BY count DESC, tags.name"), userFrom(ctx), userFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (r *sqlNoteRepository) List(ctx context.Context, params ListParams) (*NotePage, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	where := []string{"deleted_at IS NULL", "owner = ?"}
+	switch {
+	case params.Shared:
+		where[1] = "id IN (SELECT note_id FROM note_shares WHERE username = ?)"
+	case params.Trashed:
+		where[0] = "deleted_at IS NOT NULL"
+	}
+	args := []any{userFrom(ctx)}
+	if !params.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, params.CreatedFrom.UTC())
+	}
+	if !params.CreatedTo.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, params.CreatedTo.UTC())
+	}
+	if len(params.Tags) > 0 {
+		// the notes with all the tags have as many of them as asked
+		having := ""
+		if params.TagMode == "all" {
+			having = fmt.Sprintf(" GROUP BY note_tags.note_id HAVING COUNT(*) = %d", len(params.Tags))
+		}
+		where = append(where, "id IN (SELECT note_tags.note_id FROM note_tags JOIN tags ON tags.id = note_tags.tag_id"+
+			" WHERE tags.name IN ("+placeholders(len(params.Tags))+")"+having+")")
+		for _, tag := range params.Tags {
+			args = append(args, tag)
+		}
+	}
+	filter := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	err := r.db.QueryRowContext(ctx, r.bind("SELECT COUNT(*) FROM notes"+filter), args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// The id breaks the ties between notes with the same sort value, so that
+	// the order is total and a cursor points to exactly one place
+	cmp := ">"
+	if params.Order == "desc" {
+		cmp = "<"
+	}
+	if params.Cursor != "" {
+		c, _ := decodeCursor(params.Cursor)
+		value, err := cursorArg(c)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", params.Sort, cmp))
+		args = append(args, value, value, c.Id)
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+	query := fmt.Sprintf(
+		"SELECT "+noteColumns+" FROM notes%s ORDER BY %s %s, id %s LIMIT ? OFFSET ?",
+		filter, params.Sort, params.Order, params.Order)
+	// one more note than asked tells whether there is a next page
+	rows, err := r.db.QueryContext(ctx, r.bind(query), append(args, params.Limit+1, params.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notes := []Note{}
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	page := newPage(notes, total, params)
+	if err := r.loadTags(ctx, r.db, notePointers(page.Notes)...); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// search runs a search query returning the note columns but deleted_at,
+// followed by the highlighted title, the snippet and the score
+func (r *sqlNoteRepository) search(ctx context.Context, query string, args ...any) ([]SearchResult, error) {
+	rows, err := r.db.QueryContext(ctx, r.bind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		err := rows.Scan(
+			&result.Note.Id,
+			&result.Note.Owner,
+			&result.Note.Title,
+			&result.Note.Body,
+			&result.Note.CreatedAt,
+			&result.Note.UpdatedAt,
+			&result.Note.Version,
+			&result.Title,
+			&result.Snippet,
+			&result.Score)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	notes := make([]*Note, len(results))
+	for i := range results {
+		notes[i] = &results[i].Note
+	}
+	return results, r.loadTags(ctx, r.db, notes...)
+}
+
+// notePointers returns pointers to the notes of a slice
+func notePointers(notes []Note) []*Note {
+	pointers := make([]*Note, len(notes))
+	for i := range notes {
+		pointers[i] = &notes[i]
+	}
+	return pointers
+}
+
+const revisionColumns = "note_id, revision, title, body, author, created_at"
+
+func scanRevision(row interface{ Scan(...any) error }) (*Revision, error) {
+	var rev Revision
+	err := row.Scan(&rev.NoteId, &rev.Number, &rev.Title, &rev.Body, &rev.Author, &rev.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	return &rev, err
+}
+
+// recordRevision records the title and body of the note as its next
+// revision. The update of the note that comes before locks it, so that the
+// revisions of concurrent changes get different numbers.
+func (r *sqlNoteRepository) recordRevision(ctx context.Context, tx *sql.Tx, note *Note) error {
+	_, err := tx.ExecContext(ctx, r.bind(
+		"INSERT INTO note_revisions ("+revisionColumns+") VALUES "+
+			"(?, (SELECT COALESCE(MAX(revision), 0) + 1 FROM note_revisions WHERE note_id = ?), ?, ?, ?, ?)"),
+		note.Id, note.Id, note.Title, note.Body, userFrom(ctx), note.UpdatedAt)
+	return err
+}
+
+// access returns the access of the user to a note, empty when they do not
+// see it
+func (r *sqlNoteRepository) access(ctx context.Context, q querier, id int) (Access, error) {
+	user := userFrom(ctx)
+	var owner string
+	var shared sql.NullString
+	err := q.QueryRowContext(ctx, r.bind(
+		"SELECT owner, (SELECT access FROM note_shares WHERE note_id = notes.id AND username = ?)"+
+			" FROM notes WHERE id = ? AND deleted_at IS NULL"), user, id).Scan(&owner, &shared)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "", nil
+	case err != nil:
+		return "", err
+	case owner == user:
+		return AccessOwner, nil
+	}
+	return Access(shared.String), nil
+}
+
+// authorize returns nil when the user has the access needed to the note,
+// and ErrNoteNotFound, ErrReadOnly or ErrNotOwner otherwise
+func (r *sqlNoteRepository) authorize(ctx context.Context, q querier, id int, need Access) error {
+	access, err := r.access(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	return access.allows(need)
+}
+
+// exists returns ErrNoteNotFound if there is no note with the id
+func (r *sqlNoteRepository) exists(ctx context.Context, q querier, id int) error {
+	var one int
+	err := q.QueryRowContext(ctx, r.bind("SELECT 1 FROM notes WHERE id = ? AND deleted_at IS NULL"), id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoteNotFound
+	}
+	return err
+}
+
+func (r *sqlNoteRepository) Revisions(ctx context.Context, id int) ([]Revision, error) {
+	if err := r.authorize(ctx, r.db, id, AccessRead); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, r.bind(
+		"SELECT "+revisionColumns+" FROM note_revisions WHERE note_id = ? ORDER BY revision DESC"), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+	return revisions, rows.Err()
+}
+
+// revision returns a revision of a note the user has the access needed to
+func (r *sqlNoteRepository) revision(ctx context.Context, q querier, id, number int, need Access) (*Revision, error) {
+	if err := r.authorize(ctx, q, id, need); err != nil {
+		return nil, err
+	}
+	return scanRevision(q.QueryRowContext(ctx, r.bind(
+		"SELECT "+revisionColumns+" FROM note_revisions WHERE note_id = ? AND revision = ?"), id, number))
+}
+
+func (r *sqlNoteRepository) Revision(ctx context.Context, id, number int) (*Revision, error) {
+	return r.revision(ctx, r.db, id, number, AccessRead)
+}
+
+func (r *sqlNoteRepository) Restore(ctx context.Context, id, number, version int) (*Note, error) {
+	var note *Note
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		rev, err := r.revision(ctx, tx, id, number, AccessWrite)
+		if err != nil {
+			return err
+		}
+		note, err = scanNote(tx.QueryRowContext(ctx, r.bind(
+			"UPDATE notes SET title = ?, body = ?, updated_at = ?, version = version + 1"+
+				" WHERE id = ? AND deleted_at IS NULL"+versionCheck+" RETURNING "+noteColumns),
+			rev.Title, rev.Body, time.Now().UTC(), id, version, version))
+		if err != nil {
+			return r.changeError(ctx, tx, id, err)
+		}
+		if err := r.recordRevision(ctx, tx, note); err != nil {
+			return err
+		}
+		return r.loadTags(ctx, tx, note)
+	})
+	return note, err
+}
+
+func (r *sqlNoteRepository) Share(ctx context.Context, id int, params ShareParams) (*Share, error) {
+	share := &Share{NoteId: id, Username: params.Username, Access: params.Access}
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.authorize(ctx, tx, id, AccessOwner); err != nil {
+			return err
+		}
+		if params.Username == userFrom(ctx) {
+			return ErrShareOwner
+		}
+		// a share that is changed keeps its creation time
+		return tx.QueryRowContext(ctx, r.bind(
+			"INSERT INTO note_shares (note_id, username, access, created_at) VALUES (?, ?, ?, ?)"+
+				" ON CONFLICT (note_id, username) DO UPDATE SET access = excluded.access RETURNING created_at"),
+			id, params.Username, string(params.Access), time.Now().UTC()).Scan(&share.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+func (r *sqlNoteRepository) Unshare(ctx context.Context, id int, username string) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.authorize(ctx, tx, id, AccessOwner); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, r.bind("DELETE FROM note_shares WHERE note_id = ? AND username = ?"), id, username)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrShareNotFound
+		}
+		return nil
+	})
+}
+
+func (r *sqlNoteRepository) Shares(ctx context.Context, id int) ([]Share, error) {
+	if err := r.authorize(ctx, r.db, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, r.bind(
+		"SELECT note_id, username, access, created_at FROM note_shares WHERE note_id = ? ORDER BY username"), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := []Share{}
+	for rows.Next() {
+		var share Share
+		if err := rows.Scan(&share.NoteId, &share.Username, &share.Access, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func (r *sqlNoteRepository) CreateLink(ctx context.Context, id int, expiresAt time.Time) (*ShareLink, error) {
+	token, hash, err := newLinkToken()
+	if err != nil {
+		return nil, err
+	}
+	link := &ShareLink{NoteId: id, CreatedAt: time.Now().UTC(), ExpiresAt: expiresAt.UTC(), Token: token}
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.authorize(ctx, tx, id, AccessOwner); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, r.bind(
+			"INSERT INTO share_links (note_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?) RETURNING id"),
+			id, hash, link.CreatedAt, link.ExpiresAt).Scan(&link.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (r *sqlNoteRepository) Links(ctx context.Context, id int) ([]ShareLink, error) {
+	if err := r.authorize(ctx, r.db, id, AccessOwner); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, r.bind(
+		"SELECT id, note_id, created_at, expires_at FROM share_links WHERE note_id = ? AND expires_at > ? ORDER BY id"),
+		id, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := []ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		if err := rows.Scan(&link.Id, &link.NoteId, &link.CreatedAt, &link.ExpiresAt); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (r *sqlNoteRepository) DeleteLink(ctx context.Context, id, linkId int) error {
+	return r.inTx(ctx, func(tx *sql.Tx) error {
+		if err := r.authorize(ctx, tx, id, AccessOwner); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, r.bind("DELETE FROM share_links WHERE id = ? AND note_id = ?"), linkId, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrLinkNotFound
+		}
+		return nil
+	})
+}
+
+func (r *sqlNoteRepository) SharedNote(ctx context.Context, token string) (*Note, error) {
+	note, err := scanNote(r.db.QueryRowContext(ctx, r.bind(
+		"SELECT "+noteColumns+" FROM notes WHERE deleted_at IS NULL AND id ="+
+			" (SELECT note_id FROM share_links WHERE token_hash = ? AND expires_at > ?)"),
+		hashLinkToken(token), time.Now().UTC()))
+	if errors.Is(err, ErrNoteNotFound) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return note, r.loadTags(ctx, r.db, note)
+}
@@ -0,0 +1,11 @@
+package models
+
+import "testing"
+
+func TestBindDollar(t *testing.T) {
+	got := bindDollar("SELECT id FROM notes WHERE owner = ? AND id IN (?, ?)" + versionCheck)
+	want := "SELECT id FROM notes WHERE owner = $1 AND id IN ($2, $3) AND ($4 = 0 OR version = $5)"
+	if got != want {
+		t.Errorf("got %q", got)
+	}
+}
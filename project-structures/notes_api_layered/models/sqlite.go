@@ -0,0 +1,37 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLiteNoteRepository is a NoteRepository backed by a SQLite database, which
+// must be built with FTS5 for the search.
+type SQLiteNoteRepository struct {
+	sqlNoteRepository
+}
+
+func NewSQLiteNoteRepository(db *sql.DB) *SQLiteNoteRepository {
+	return &SQLiteNoteRepository{sqlNoteRepository{db: db, bind: func(query string) string { return query }}}
+}
+
+// Search ranks the notes the user can read with BM25, a match in the title counting ten times
+// as much as a match in the body.
+func (r *SQLiteNoteRepository) Search(ctx context.Context, search string, limit int) ([]SearchResult, error) {
+	query := ftsQuery(search)
+	if query == "" {
+		return nil, ErrEmptyQuery
+	}
+	if limit < 1 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+	return r.search(ctx, `
+SELECT notes.id, notes.owner, notes.title, notes.body, notes.created_at, notes.updated_at, notes.version,
+  highlight(notes_fts, 0, '<mark>', '</mark>'),
+  snippet(notes_fts, -1, '<mark>', '</mark>', '…', 16),
+  -bm25(notes_fts, 10.0, 1.0) AS score
+FROM notes_fts JOIN notes ON notes.id = notes_fts.rowid
+WHERE notes_fts MATCH ? AND notes.deleted_at IS NULL AND `+readable+`
+ORDER BY score DESC
+LIMIT ?`, query, userFrom(ctx), userFrom(ctx), limit)
+}
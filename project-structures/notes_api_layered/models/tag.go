@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The limits on the tags of a note. A tag must fit the VARCHAR(32) column.
+const (
+	MaxTags      = 20
+	MaxTagLength = 32
+)
+
+// TagCount is a tag along with the number of notes it is on
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag returns the canonical form of a tag name: in lower case, its
+// words joined with single dashes, so that "Go Lang", "go_lang" and
+// "go-lang" are the same tag.
+func NormalizeTag(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '_' || r == '-'
+	})
+	return strings.Join(words, "-")
+}
+
+// normalizeTags returns the tags normalized, sorted and without duplicates.
+// The empty ones are dropped.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// validateTags checks normalized tags, reporting the problems on field
+func validateTags(errs *ValidationError, field string, tags []string) {
+	if len(tags) > MaxTags {
+		errs.Add(field, fmt.Sprintf("must have at most %d tags", MaxTags))
+		return
+	}
+	for _, tag := range tags {
+		valid := utf8.RuneCountInString(tag) <= MaxTagLength && strings.IndexFunc(tag, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+		}) < 0
+		if !valid {
+			errs.Add(field, fmt.Sprintf("%q must be at most %d letters, digits or dashes", tag, MaxTagLength))
+		}
+	}
+}
+
+// hasTags tells whether a note with the sorted tags noteTags matches a filter
+// on tags: all of them with the "all" mode, one of them with "any"
+func hasTags(noteTags, tags []string, mode string) bool {
+	found := 0
+	for _, tag := range tags {
+		if _, ok := slices.BinarySearch(noteTags, tag); ok {
+			found++
+		}
+	}
+	if mode == "any" {
+		return found > 0 || len(tags) == 0
+	}
+	return found == len(tags)
+}
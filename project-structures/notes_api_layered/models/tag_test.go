@@ -0,0 +1,19 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" Go ", "go", "Web_Dev", "web -- dev", "", "  ", "Ünïcode"})
+	if fmt.Sprint(got) != "[go web-dev ünïcode]" {
+		t.Errorf("got %q", got)
+	}
+
+	var errs ValidationError
+	validateTags(&errs, "tags", []string{"go", "c++", "a-very-long-tag-that-does-not-fit-in-32"})
+	if len(errs.Errors) != 2 {
+		t.Errorf("got %v", errs.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/favtuts/problem"
+)
+
+// The limits on the length of the fields of a note, in characters. The
+// title must fit the VARCHAR(64) column.
+const (
+	MaxTitleLength = 64
+	MaxBodyLength  = 100000
+)
+
+// The validation errors are the ones of the problem package, which lists
+// their fields in the answers
+type (
+	FieldError      = problem.FieldError
+	ValidationError = problem.ValidationError
+)
+
+func validateTitle(errs *ValidationError, title string) {
+	switch {
+	case title == "":
+		errs.Add("title", "is required")
+	case utf8.RuneCountInString(title) > MaxTitleLength:
+		errs.Add("title", "must be at most 64 characters")
+	case strings.IndexFunc(title, unicode.IsControl) >= 0:
+		errs.Add("title", "must be a single line of text")
+	}
+}
+
+func validateBody(errs *ValidationError, body string) {
+	if utf8.RuneCountInString(body) > MaxBodyLength {
+		errs.Add("body", "must be at most 100000 characters")
+	}
+}
+
+// Normalize trims the spaces around the title and the body, and normalizes
+// the tags
+func (p *NoteParams) Normalize() {
+	p.Title = strings.TrimSpace(p.Title)
+	p.Body = strings.TrimSpace(p.Body)
+	p.Tags = normalizeTags(p.Tags)
+}
+
+// Validate checks the fields of a note, once normalized. The title is
+// required, the body and the tags may be empty.
+func (p *NoteParams) Validate() error {
+	var errs ValidationError
+	validateTitle(&errs, p.Title)
+	validateBody(&errs, p.Body)
+	validateTags(&errs, "tags", p.Tags)
+	return errs.Err()
+}
+
+// Normalize trims the spaces around the fields that are set
+func (p *NotePatch) Normalize() {
+	if p.Title != nil {
+		*p.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.Body != nil {
+		*p.Body = strings.TrimSpace(*p.Body)
+	}
+	if p.Tags != nil {
+		*p.Tags = normalizeTags(*p.Tags)
+	}
+}
+
+// Validate checks the fields that are set with the rules of NoteParams
+func (p *NotePatch) Validate() error {
+	var errs ValidationError
+	if p.Title != nil {
+		validateTitle(&errs, *p.Title)
+	}
+	if p.Body != nil {
+		validateBody(&errs, *p.Body)
+	}
+	if p.Tags != nil {
+		validateTags(&errs, "tags", *p.Tags)
+	}
+	return errs.Err()
+}
@@ -1,9 +1,14 @@
 module ratelimiting
 
-go 1.18
+go 1.21
 
 require (
-	github.com/didip/tollbooth/v7 v7.0.2 // indirect
-	github.com/go-pkgz/expirable-cache/v3 v3.0.0 // indirect
-	golang.org/x/time v0.6.0 // indirect
+	github.com/didip/tollbooth/v7 v7.0.2
+	github.com/favtuts/problem v0.0.0
+	golang.org/x/time v0.6.0
 )
+
+require github.com/go-pkgz/expirable-cache/v3 v3.0.0 // indirect
+
+// The shared problem details package, at the root of the repository
+replace github.com/favtuts/problem => ../problem
@@ -2,13 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/didip/tollbooth/v7"
+	"github.com/favtuts/problem"
 	"golang.org/x/time/rate"
 )
 
@@ -34,13 +39,7 @@ func rateLimiter(next func(w http.ResponseWriter, r *http.Request)) http.Handler
 	limiter := rate.NewLimiter(2, 4)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !limiter.Allow() {
-			message := Message{
-				Status: "Request Failed",
-				Body:   "The API is at capacity, try again later.",
-			}
-
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(&message)
+			writeRateLimited(w, r, time.Second)
 			return
 		} else {
 			next(w, r)
@@ -48,7 +47,37 @@ func rateLimiter(next func(w http.ResponseWriter, r *http.Request)) http.Handler
 	})
 }
 
-func perClientRateLimiter(next func(writer http.ResponseWriter, request *http.Request)) http.Handler {
+// writeRateLimited answers 429 Too Many Requests as a problem, telling the
+// client when it may try again
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	problem.Error(w, r, http.StatusTooManyRequests, "rate_limited", "The API is at capacity, try again later.")
+}
+
+// limiterOptions configures perClientRateLimiter.
+type limiterOptions struct {
+	// logger receives one record per rejected request at rejectLevel.
+	logger      *slog.Logger
+	rejectLevel slog.Level
+	// metrics, when set, counts allowed, rejected and queued requests.
+	metrics *limiterMetrics
+	// routes are the paths the limiter is registered for, used as the route
+	// label of the records and metrics. Any other path is labelled routeOther
+	// so that clients cannot create series by requesting arbitrary paths.
+	routes []string
+	// maxWait lets a request wait for a token instead of being rejected
+	// when one becomes available within this duration. Zero disables queueing.
+	maxWait time.Duration
+	// The clients idle for idleTimeout, 3 minutes by default, are evicted
+	// every sweepInterval, 1 minute by default.
+	idleTimeout   time.Duration
+	sweepInterval time.Duration
+}
+
+// routeOther labels the requests to a path outside limiterOptions.routes.
+const routeOther = "other"
+
+func perClientRateLimiter(next func(writer http.ResponseWriter, request *http.Request), opts limiterOptions) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
 		lastSeen time.Time
@@ -57,74 +86,143 @@ func perClientRateLimiter(next func(writer http.ResponseWriter, request *http.Re
 		mu      sync.Mutex
 		clients = make(map[string]*client)
 	)
+	if opts.logger == nil {
+		opts.logger = slog.Default()
+	}
+	if opts.idleTimeout == 0 {
+		opts.idleTimeout = 3 * time.Minute
+	}
+	if opts.sweepInterval == 0 {
+		opts.sweepInterval = time.Minute
+	}
+	record := func(ip, route, outcome string) {
+		if opts.metrics != nil {
+			opts.metrics.inc(ip, route, outcome)
+		}
+	}
 	go func() {
 		for {
-			time.Sleep(time.Minute)
+			time.Sleep(opts.sweepInterval)
 			// Lock the mutex to protect this section from race conditions.
 			mu.Lock()
 			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
+				if time.Since(client.lastSeen) > opts.idleTimeout {
 					delete(clients, ip)
+					if opts.metrics != nil {
+						opts.metrics.forget(ip)
+					}
 				}
 			}
+			if opts.metrics != nil {
+				opts.metrics.setVisitors(len(clients))
+			}
 			mu.Unlock()
 		}
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract the IP address from the request.
-		log.Println("Extracting client ip ...")
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			opts.logger.Error("cannot parse client address", "remote_addr", r.RemoteAddr, "error", err,
+				"trace_id", problem.TraceID(r))
+			problem.Error(w, r, http.StatusInternalServerError, "", "")
 			return
-		} else {
-			log.Println("Client ip found: " + ip)
+		}
+		route := routeOther
+		for _, known := range opts.routes {
+			if r.URL.Path == known {
+				route = known
+				break
+			}
 		}
 		// Lock the mutex to protect this section from race conditions.
 		mu.Lock()
 		if _, found := clients[ip]; !found {
 			clients[ip] = &client{limiter: rate.NewLimiter(2, 4)}
+			if opts.metrics != nil {
+				opts.metrics.setVisitors(len(clients))
+			}
 		}
 		clients[ip].lastSeen = time.Now()
-		log.Println("Checking rate limiting for IP: " + ip)
-		if !clients[ip].limiter.Allow() {
+		reservation := clients[ip].limiter.Reserve()
+		delay := reservation.Delay()
+		if delay > opts.maxWait {
+			reservation.Cancel()
 			mu.Unlock()
 
-			message := Message{
-				Status: "Request Failed",
-				Body:   "The API is at capacity, try again later.",
-			}
-
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(&message)
-
-			log.Println("Rate liming failed for IP: " + ip)
+			record(ip, route, outcomeRejected)
+			opts.logger.Log(r.Context(), opts.rejectLevel, "rate limit exceeded",
+				"client_ip", ip, "method", r.Method, "route", route, "path", r.URL.Path, "retry_after", delay.String(),
+				"trace_id", problem.TraceID(r))
 
+			writeRateLimited(w, r, delay)
 			return
 		}
 		mu.Unlock()
+
+		if delay > 0 {
+			record(ip, route, outcomeQueued)
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				reservation.Cancel()
+				return
+			}
+		} else {
+			record(ip, route, outcomeAllowed)
+		}
 		next(w, r)
 	})
 }
 
+// tollboothHandler rate limits endpointHandler with Tollbooth at one request per second.
+// The rejections are answered by writeRateLimited instead of Tollbooth's fixed message.
+func tollboothHandler() http.Handler {
+	tlbthLimiter := tollbooth.NewLimiter(1, nil)
+	tlbthLimiter.SetOverrideDefaultResponseWriter(true)
+	tlbthLimiter.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
+		writeRateLimited(w, r, time.Second)
+	})
+	return tollbooth.LimitFuncHandler(tlbthLimiter, endpointHandler)
+}
+
 func main() {
+	limiter := flag.String("limiter", "tollbooth", "rate limiter for /ping: tollbooth or perclient")
+	logLevel := flag.String("reject-log-level", "info", "log level for rejected requests: debug, info, warn or error")
+	maxWait := flag.Duration("max-wait", 0, "how long a request may queue for a token before it is rejected")
+	flag.Parse()
+
+	var rejectLevel slog.Level
+	if err := rejectLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("Invalid -reject-log-level %q: %v", *logLevel, err)
+	}
+
 	log.Println("Starting the web application...")
 	// http.Handle("/ping", rateLimiter(endpointHandler))
-	// http.Handle("/ping", perClientRateLimiter(endpointHandler))
 
-	message := Message{
-		Status: "Request Failed",
-		Body:   "The API is at capacity, try again later.",
+	// The per client limiter is opt-in, the tutorial serves /ping with Tollbooth
+	switch *limiter {
+	case "tollbooth":
+		http.Handle("/ping", tollboothHandler())
+	case "perclient":
+		metrics := newLimiterMetrics()
+		http.Handle("/ping", perClientRateLimiter(endpointHandler, limiterOptions{
+			logger:      slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: rejectLevel})),
+			rejectLevel: rejectLevel,
+			metrics:     metrics,
+			routes:      []string{"/ping"},
+			maxWait:     *maxWait,
+		}))
+		http.Handle("/metrics", metrics)
+	default:
+		log.Fatalf("Unknown -limiter %q", *limiter)
 	}
-	jsonMessage, _ := json.Marshal(message)
-
-	tlbthLimiter := tollbooth.NewLimiter(1, nil)
-	tlbthLimiter.SetMessageContentType("application/json")
-	tlbthLimiter.SetMessage(string(jsonMessage))
-	http.Handle("/ping", tollbooth.LimitFuncHandler(tlbthLimiter, endpointHandler))
 
-	err := http.ListenAndServe(":8080", nil)
+	// The middleware gives every request the trace id of its problems and logs
+	err := http.ListenAndServe(":8080", problem.Middleware(http.DefaultServeMux))
 	if err != nil {
 		log.Println("There was an error listening on port :8080", err)
 	}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ping sends a request to /ping from the client address and returns the
+// status code
+func ping(h http.Handler, addr string) int {
+	r := httptest.NewRequest("GET", "/ping", nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+// scrape returns the /metrics text of m
+func scrape(m *limiterMetrics) string {
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestRejectionsAreLogged(t *testing.T) {
+	var logs bytes.Buffer
+	h := perClientRateLimiter(endpointHandler, limiterOptions{
+		logger:      slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		rejectLevel: slog.LevelWarn,
+	})
+	// the burst of 4 passes without a record, the fifth request is rejected
+	for i := 0; i < 4; i++ {
+		if code := ping(h, "192.0.2.1:1234"); code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i+1, code)
+		}
+	}
+	if logs.Len() != 0 {
+		t.Errorf("allowed requests were logged: %s", logs.String())
+	}
+	if code := ping(h, "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Fatalf("request 5: got %d", code)
+	}
+	record := logs.String()
+	if strings.Count(record, "\n") != 1 || !strings.Contains(record, `"level":"WARN"`) ||
+		!strings.Contains(record, `"msg":"rate limit exceeded"`) || !strings.Contains(record, `"client_ip":"192.0.2.1"`) {
+		t.Errorf("rejection record: got %s", record)
+	}
+
+	// a level below the one of the handler is not written
+	logs.Reset()
+	h = perClientRateLimiter(endpointHandler, limiterOptions{
+		logger:      slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo})),
+		rejectLevel: slog.LevelDebug,
+	})
+	for i := 0; i < 5; i++ {
+		ping(h, "192.0.2.1:1234")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("a debug rejection was logged at info: %s", logs.String())
+	}
+}
+
+func TestQueueing(t *testing.T) {
+	metrics := newLimiterMetrics()
+	h := perClientRateLimiter(endpointHandler, limiterOptions{metrics: metrics, routes: []string{"/ping"}, maxWait: time.Second})
+	for i := 0; i < 4; i++ {
+		ping(h, "192.0.2.1:1234")
+	}
+	// the fifth token comes after 500ms at 2 per second, within maxWait
+	start := time.Now()
+	if code := ping(h, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Fatalf("queued request: got %d", code)
+	}
+	if waited := time.Since(start); waited < 300*time.Millisecond {
+		t.Errorf("the queued request waited %s", waited)
+	}
+
+	// a token further away than maxWait is rejected at once
+	short := perClientRateLimiter(endpointHandler, limiterOptions{metrics: metrics, routes: []string{"/ping"}, maxWait: 100 * time.Millisecond})
+	for i := 0; i < 4; i++ {
+		ping(short, "192.0.2.2:1234")
+	}
+	start = time.Now()
+	if code := ping(short, "192.0.2.2:1234"); code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond maxWait: got %d", code)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("the rejected request waited %s", waited)
+	}
+
+	got := scrape(metrics)
+	for _, want := range []string{
+		`ratelimit_requests_total{key="192.0.2.1",route="/ping",outcome="allowed"} 4`,
+		`ratelimit_requests_total{key="192.0.2.1",route="/ping",outcome="queued"} 1`,
+		`ratelimit_requests_total{key="192.0.2.2",route="/ping",outcome="allowed"} 4`,
+		`ratelimit_requests_total{key="192.0.2.2",route="/ping",outcome="rejected"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, got)
+		}
+	}
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	metrics := newLimiterMetrics()
+	h := perClientRateLimiter(endpointHandler, limiterOptions{metrics: metrics, routes: []string{"/ping"}})
+	ping(h, "192.0.2.1:1234")
+	for _, path := range []string{"/a", "/b", "/ping/x"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	got := scrape(metrics)
+	for _, want := range []string{
+		`ratelimit_requests_total{key="192.0.2.1",route="/ping",outcome="allowed"} 1`,
+		`ratelimit_requests_total{key="192.0.2.1",route="other",outcome="allowed"} 3`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `route="/a"`) {
+		t.Errorf("the unknown paths have their own series:\n%s", got)
+	}
+}
+
+func TestMetricsFormat(t *testing.T) {
+	m := newLimiterMetrics()
+	m.inc("192.0.2.2", "/ping", outcomeRejected)
+	m.inc("192.0.2.1", "/ping", outcomeAllowed)
+	m.inc("192.0.2.1", "/ping", outcomeAllowed)
+	m.inc("192.0.2.1", `/a"b\`, outcomeQueued)
+	m.setVisitors(2)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP ratelimit_requests_total Requests seen by the per-client rate limiter.
+# TYPE ratelimit_requests_total counter
+ratelimit_requests_total{key="192.0.2.1",route="/a\"b\\",outcome="queued"} 1
+ratelimit_requests_total{key="192.0.2.1",route="/ping",outcome="allowed"} 2
+ratelimit_requests_total{key="192.0.2.2",route="/ping",outcome="rejected"} 1
+# HELP ratelimit_visitors Clients currently tracked by the per-client rate limiter.
+# TYPE ratelimit_visitors gauge
+ratelimit_visitors 2
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("metrics: got\n%s\nwant\n%s", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("content type: got %q", ct)
+	}
+}
+
+func TestEvictionForgetsMetrics(t *testing.T) {
+	metrics := newLimiterMetrics()
+	h := perClientRateLimiter(endpointHandler, limiterOptions{
+		metrics:       metrics,
+		idleTimeout:   20 * time.Millisecond,
+		sweepInterval: 10 * time.Millisecond,
+	})
+	ping(h, "192.0.2.1:1234")
+	if got := scrape(metrics); !strings.Contains(got, `key="192.0.2.1"`) || !strings.Contains(got, "ratelimit_visitors 1\n") {
+		t.Fatalf("metrics before the eviction:\n%s", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for got := scrape(metrics); strings.Contains(got, `key="192.0.2.1"`) || !strings.Contains(got, "ratelimit_visitors 0\n"); got = scrape(metrics) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the counters of an evicted client are kept:\n%s", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Outcomes recorded by the per-client rate limiter.
+const (
+	outcomeAllowed  = "allowed"
+	outcomeRejected = "rejected"
+	outcomeQueued   = "queued"
+)
+
+type metricKey struct {
+	key     string
+	route   string
+	outcome string
+}
+
+// limiterMetrics keeps request counters per client key and route, plus a
+// gauge of the visitors currently tracked by the limiter. The counters of a
+// client are dropped when the limiter evicts it. It is served in the
+// Prometheus text exposition format.
+type limiterMetrics struct {
+	mu       sync.Mutex
+	requests map[metricKey]uint64
+	visitors int
+}
+
+func newLimiterMetrics() *limiterMetrics {
+	return &limiterMetrics{requests: make(map[metricKey]uint64)}
+}
+
+func (m *limiterMetrics) inc(key, route, outcome string) {
+	m.mu.Lock()
+	m.requests[metricKey{key: key, route: route, outcome: outcome}]++
+	m.mu.Unlock()
+}
+
+// forget deletes the counters of a client key, once the limiter no longer
+// tracks it, so that the series do not grow with every client ever seen.
+func (m *limiterMetrics) forget(key string) {
+	m.mu.Lock()
+	for k := range m.requests {
+		if k.key == key {
+			delete(m.requests, k)
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *limiterMetrics) setVisitors(n int) {
+	m.mu.Lock()
+	m.visitors = n
+	m.mu.Unlock()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *limiterMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	keys := make([]metricKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	counts := make([]uint64, len(keys))
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].key != keys[j].key {
+			return keys[i].key < keys[j].key
+		}
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+	for i, k := range keys {
+		counts[i] = m.requests[k]
+	}
+	visitors := m.visitors
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP ratelimit_requests_total Requests seen by the per-client rate limiter.")
+	fmt.Fprintln(w, "# TYPE ratelimit_requests_total counter")
+	for i, k := range keys {
+		fmt.Fprintf(w, "ratelimit_requests_total{key=\"%s\",route=\"%s\",outcome=\"%s\"} %d\n",
+			labelEscaper.Replace(k.key), labelEscaper.Replace(k.route), k.outcome, counts[i])
+	}
+	fmt.Fprintln(w, "# HELP ratelimit_visitors Clients currently tracked by the per-client rate limiter.")
+	fmt.Fprintln(w, "# TYPE ratelimit_visitors gauge")
+	fmt.Fprintf(w, "ratelimit_visitors %d\n", visitors)
+}